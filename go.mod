@@ -6,4 +6,5 @@ require (
 	github.com/gorilla/websocket v1.5.1
 	github.com/jpillora/backoff v1.0.0
 	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/time v0.5.0
 )
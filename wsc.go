@@ -1,33 +1,237 @@
 package wsc
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/jpillora/backoff"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultMaxMessageSize 默认支持接受的消息最大长度
+	DefaultMaxMessageSize int64 = 10 * 1024 * 1024
+	// UnlimitedMessageSize 设置为MaxMessageSize时不限制接受的消息长度
+	UnlimitedMessageSize int64 = math.MaxInt64
+	// DefaultCloseTimeout 默认断开底层连接的最长等待时间
+	DefaultCloseTimeout = time.Second
+	// DefaultCompressionMinSize 默认压缩的消息最小长度
+	DefaultCompressionMinSize = 512
+	// DefaultChunkSize SendBinaryStream默认的分块大小
+	DefaultChunkSize = 32 * 1024
 )
 
 var (
 	ErrClose  = errors.New("connection closed")
 	ErrBuffer = errors.New("message buffer is full")
+	// ErrInvalidConfig 配置不合法
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrInvalidCloseCode 关闭码不允许在关闭帧中发送
+	ErrInvalidCloseCode = errors.New("invalid close code")
+	// ErrRequestPending 相同id的请求正在等待响应
+	ErrRequestPending = errors.New("request with the same id is pending")
+	// ErrAlreadyConnected 已连接时再次发起连接
+	ErrAlreadyConnected = errors.New("already connected")
+	// ErrAlreadyConnecting 正在连接时再次发起连接
+	ErrAlreadyConnecting = errors.New("already connecting")
+	// ErrInterceptorPanic 开启RecoverCallbacks时拦截器panic，消息不再发送或分发
+	ErrInterceptorPanic = errors.New("interceptor panicked")
+	// ErrReaderAborted 流式发送时读取消息数据失败，gorilla无法中止已开始的消息，为避免对端收到被截断却完整的消息，连接随之断开
+	ErrReaderAborted = errors.New("message reader failed")
+
+	// errExpired 消息在缓冲通道中已过期，仅内部使用
+	errExpired = errors.New("message expired")
 )
 
+// HandshakeError 握手时服务端返回非101响应，可通过errors.As获取状态码和响应头，
+// errors.Is(err, websocket.ErrBadHandshake)依然成立
+type HandshakeError struct {
+	// HTTP状态码
+	StatusCode int
+	// HTTP响应头，如Retry-After
+	Header http.Header
+	// 完整的HTTP响应
+	Response *http.Response
+	// 底层错误
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("%v (status %d)", e.Err, e.StatusCode)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter 解析Retry-After响应头，支持秒数和HTTP日期两种格式
+func (e *HandshakeError) RetryAfter() (time.Duration, bool) {
+	value := e.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		d := time.Until(date)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// ClosedError 连接已关闭，携带最后一次断开的关闭码和原因，errors.Is(err, ErrClose)成立，
+// 从未连接过时返回的是ErrClose本身
+type ClosedError struct {
+	// 关闭码，非关闭帧导致的断开为websocket.CloseAbnormalClosure
+	Code int
+	// 关闭原因
+	Text string
+	// 导致断开的底层错误，如*websocket.CloseError，客户端主动关闭时为nil
+	Err error
+}
+
+func (e *ClosedError) Error() string {
+	return fmt.Sprintf("%v: code %d %s", ErrClose, e.Code, e.Text)
+}
+
+func (e *ClosedError) Is(target error) bool {
+	return target == ErrClose
+}
+
+func (e *ClosedError) Unwrap() error {
+	return e.Err
+}
+
 type Wsc struct {
-	// 配置信息
+	// 当前连接过程中连续失败的次数，原子操作，放在首位保证64位对齐
+	reconnectAttempts int64
+	// 下次重连前的等待时间，原子操作
+	nextReconnectDelay int64
+	// SetReadLimit设置的消息最大长度，大于0时代替配置，原子操作
+	maxMessageSize int64
+	// 最近一次收到消息、ping或pong的时间，UnixNano，原子操作
+	lastReceived int64
+	// 运行统计，原子操作
+	stats Stats
+	// 最近一次分配的消息序列号，原子操作
+	seq uint64
+	// 最近一次发送消息成功的时间，UnixNano，原子操作
+	lastSent int64
+
+	// 配置信息，连接前可直接修改字段或整体赋值，连接后应通过SetConfig、SetKeepaliveInterval等方法修改；
+	// 方法修改的配置不会写回该字段，之后再对该字段整体赋值时以新赋值的配置为准
 	Config *Config
+	// 通过方法修改后生效的配置，存储*appliedConfig，替换时整体替换，读写协程读取时无需加锁
+	config   atomic.Value
+	configMu sync.Mutex
+	// 心跳间隔变化通知，写协程收到后重置定时器
+	keepaliveReset chan struct{}
+	// 立即重连通知，等待重连间隔的协程收到后重置退避并立即尝试连接
+	reconnectKick chan struct{}
 	// 底层WebSocket
 	WebSocket *WebSocket
+	// 回调集合，存储*callbacks，注册时复制后整体替换，读写协程读取时无需加锁
+	callbacks   atomic.Value
+	callbacksMu sync.Mutex
+
+	// 每次连接成功后执行的重新订阅函数
+	resubscribes []func() error
+	resubMu      sync.Mutex
+
+	// 拉取模式的消息通道，首次调用Messages时创建
+	messages   chan Message
+	messagesMu sync.Mutex
+
+	// 等待响应的请求，key为请求id
+	pending   map[string]*pendingRequest
+	pendingMu sync.Mutex
+
+	// 等待ReadMessage的调用方，按调用顺序依次接收消息
+	readers   []chan Message
+	readersMu sync.Mutex
+
+	// 跨重连保留的退避策略，配置了ReconnectResetInterval时使用
+	recBackoff *backoff.Backoff
+	recMu      sync.Mutex
+
+	// 生命周期，Stop时取消，取消后等待中的重连协程退出
+	life       context.Context
+	lifeCancel context.CancelFunc
+	lifeMu     sync.Mutex
+	// 提交到协程池或协程中执行的任务，Stop时等待全部退出
+	tasks sync.WaitGroup
+}
+
+// Stats 运行统计，流式收发的消息只计入消息数
+type Stats struct {
+	// 发送成功的消息数
+	MessagesSent uint64
+	// 收到的消息数
+	MessagesReceived uint64
+	// 发送成功的字节数
+	BytesSent uint64
+	// 收到的字节数
+	BytesReceived uint64
+	// 断线后重连成功的次数
+	Reconnects uint64
+}
+
+// callbacks 回调集合
+type callbacks struct {
+	// 连接状态变化回调
+	onStateChange func(old, new State)
+	// 开始拨号回调，每次拨号前触发，attempt从1开始计数
+	onConnecting func(url string, attempt int)
 	// 连接成功回调
 	onConnected func()
+	// 连接成功回调，携带握手响应，可读取服务端分配的连接ID等响应头
+	onConnectedResponse func(resp *http.Response)
 	// 连接异常回调，在准备进行连接的过程中发生异常时触发
 	onConnectError func(err error)
+	// 连接异常决策回调，attempt从1开始计数，返回false时停止重连
+	onConnectErrorDecision func(err error, attempt int) bool
+	// 连接异常回调，携带本次拨号到失败的耗时，可用于区分连接被拒绝和握手超时
+	onConnectErrorTimed func(err error, elapsed time.Duration, attempt int)
+	// 连接失败后确定下次重试的等待时间时回调，err为本次失败的原因
+	onBackoff func(attempt int, delay time.Duration, err error)
 	// 连接断开回调，网络异常，服务端掉线等情况时触发
 	onDisconnected func(err error)
-	// 连接关闭回调，服务端发起关闭信号或客户端主动关闭时触发
+	// 分块流式发送的进度回调，bytesSent为当前消息累计写入的字节数
+	onSendProgress func(bytesSent int64)
+	// 回调panic时触发，携带recover的值和panic时的调用栈
+	onCallbackPanic func(recovered interface{}, stack []byte)
+	// 收到任意帧时回调，在读协程中先于其他回调执行，用于调试；关闭帧为关闭码和原因编码后的内容，
+	// 消息帧为经过入站拦截器之前的内容，流式读取的消息不回调
+	onRawFrame func(messageType int, data []byte)
+	// 连接关闭回调，服务端发起关闭信号、连接异常关闭或客户端主动关闭时触发
 	onClose func(code int, text string)
+	// 连接关闭回调，byClient表示是否由客户端主动关闭
+	onCloseDetailed func(code int, text string, byClient bool)
 
 	// 发送Text消息成功回调
 	onTextMessageSent func(message []byte)
@@ -36,6 +240,8 @@ type Wsc struct {
 
 	// 发送消息异常回调
 	onSentError func(err error)
+	// 发送消息超时回调，在发送异常回调之后触发，可用于重新发送
+	onWriteTimeout func(message []byte)
 
 	// 接受到Ping消息回调
 	onPingReceived func(appData string)
@@ -45,15 +251,57 @@ type Wsc struct {
 	onTextMessageReceived func(message []byte)
 	// 接受到Binary消息回调
 	onBinaryMessageReceived func(data []byte)
+	// 接受到Text消息回调，携带从连接读出该消息的时间
+	onTextMessageReceivedAt func(message []byte, readAt time.Time)
+	// 接受到Binary消息回调，携带从连接读出该消息的时间
+	onBinaryMessageReceivedAt func(data []byte, readAt time.Time)
+	// 接受到任意数据帧回调，先于Text/Binary消息回调触发
+	onMessage func(messageType int, data []byte)
 	// 心跳
 	onKeepalive func()
+	// 消息过期丢弃回调
+	onMessageExpired func(message []byte)
+	// 缓冲通道已满时回调，丢弃消息前回调，Block策略和阻塞发送在开始等待前回调一次
+	onBufferFull func()
+	// 断线时缓冲的消息在重连成功后重新入队的回调，count为重新入队的消息数量
+	onReplay func(count int)
+	// 流式接收消息回调，开启StreamReads时代替接收消息回调
+	onMessageStream func(messageType int, r io.Reader)
+	// 收到超过最大长度的消息回调，limit为当时生效的最大长度
+	onMessageTooBig func(limit int64)
+	// 入站拦截器处理失败回调，消息被丢弃
+	onReceiveError func(err error)
+	// 收到服务端应用层心跳回调
+	onServerHeartbeat func()
+	// 按序执行回调的队列已满，消息被丢弃时回调
+	onCallbackOverflow func(messageType int, data []byte)
+
+	// 出站拦截器，按注册顺序执行
+	outbound []func(data []byte) ([]byte, error)
+	// 入站拦截器，按注册的逆序执行
+	inbound []func(data []byte) ([]byte, error)
+}
+
+// Message 接收到的数据帧
+type Message struct {
+	// 消息类型，websocket.TextMessage或websocket.BinaryMessage
+	Type int
+	// 消息内容
+	Data []byte
 }
 
 type Config struct {
 	// 写超时
 	WriteWait time.Duration
-	// 支持接受的消息最大长度，默认512字节
+	// 读超时，大于0时超过该时间未收到任何消息、ping或pong即视为断线并触发重连，用于检测半开连接，
+	// 需要配合小于该值的心跳包时间间隔使用
+	ReadTimeout time.Duration
+	// Healthy判断连接健康时允许的最长空闲时间，超过该时间未收到消息、ping或pong时Healthy返回false，但不会断开连接，0表示不检查
+	MaxIdleTime time.Duration
+	// 支持接受的消息最大长度，默认10MB，小于等于0时使用默认值，不限制长度需显式设置为UnlimitedMessageSize
 	MaxMessageSize int64
+	// 收到超过MaxMessageSize的消息断开后是否重连，默认不重连，避免服务端重复发送超长消息导致无限重连
+	ReconnectOnMessageTooBig bool
 	// 最小重连时间间隔
 	MinRecTime time.Duration
 	// 最大重连时间间隔
@@ -62,10 +310,293 @@ type Config struct {
 	RecFactor float64
 	// 消息发送缓冲池大小，默认256
 	MessageBufferSize int
-	// 心跳包时间间隔
+	// 缓冲通道已满时的处理策略，默认DropNewest
+	OverflowPolicy OverflowPolicy
+	// 保留断线时未发送的消息，开启后断开连接时缓冲通道中尚未发送的消息会被保存，下次连接成功后先于新消息重新入队，
+	// 每个缓冲通道最多保留MessageBufferSize条；正在写入连接的消息不会保留
+	PersistPending bool
+	// 为发送的Text和Binary消息添加序列号标记，入队时分配，断线重发的消息沿用原序列号，
+	// 接收方可使用Deduplicator去掉标记并忽略重复的消息；流式发送和预先编码的消息不添加标记。
+	// 标记格式为"十进制序列号:"前缀，在出站拦截器之后添加，接收方需要按同样的约定解析，
+	// 序列号在每个Wsc内从1开始递增，客户端重启后重新计数，接收方需按客户端会话区分去重范围
+	SequenceTags bool
+	// 每秒最多发送的消息数量，0表示不限制，等待期间心跳照常发送
+	SendRateLimit rate.Limit
+	// 发送速率限制允许的突发消息数量，小于等于0时为1
+	SendRateBurst int
+	// 心跳包时间间隔，默认300秒，配置了ReadTimeout时每次收到pong都会延长读超时
 	KeepaliveTime time.Duration
+	// 关闭自动心跳，开启后写协程不再定时发送ping，由调用方通过SendPing等方法自行保活，KeepaliveTime不再生效
+	DisableKeepalive bool
+	// 空闲时才发送心跳，开启后只有在KeepaliveTime内没有发送过消息时才发送心跳，繁忙的连接不再额外发送ping
+	IdleKeepalive bool
+	// 应用层心跳消息，如{"op":"ping"}，设置后每个心跳周期发送该消息代替ping帧，用于要求应用层心跳的服务端
+	HeartbeatMessage []byte
+	// 应用层心跳消息的类型，websocket.TextMessage或websocket.BinaryMessage，0表示TextMessage
+	HeartbeatType int
+	// 识别服务端发送的应用层心跳消息，如{"op":"pong"}，匹配到时触发OnServerHeartbeat，消息经过入站拦截器后再匹配
+	HeartbeatMatcher func(message []byte) bool
+	// 匹配到的心跳消息继续分发到接收消息回调，默认不分发
+	PassHeartbeats bool
 	// 允许断线重连
 	EnableReconnect bool
+	// 主动关闭时发送关闭帧后等待服务端回复关闭帧的最长时间，大于0时按RFC 6455完成关闭握手后再断开底层连接，
+	// 0表示发送关闭帧后立即断开
+	CloseGracePeriod time.Duration
+	// 断开底层连接时等待Close返回的最长时间，超时后不再等待，关闭在后台继续完成，小于等于0时使用DefaultCloseTimeout
+	CloseTimeout time.Duration
+	// 手动处理服务端关闭，开启后收到服务端关闭帧时不自动回复关闭帧也不清理连接，只触发OnClose，
+	// 调用方可在此期间继续发送消息，处理完成后需自行调用Close等方法关闭连接，未关闭前不会重连；
+	// 服务端随后断开底层连接时，下一次发送失败会按普通断线处理并在允许时重连
+	ManualCloseHandling bool
+	// 重连间隔随机抖动，默认开启，避免大量客户端同时重连；关闭后重连间隔严格按MinRecTime、MinRecTime*RecFactor...递增到MaxRecTime
+	ReconnectJitter bool
+	// 重连退避重置时间，大于0时重连间隔跨重连累计，连接持续健康超过该时间后断开才从MinRecTime重新开始，
+	// 连接反复建立后很快断开时按递增的间隔等待后再重连；为0时每次断线后立即重连且重连间隔从MinRecTime开始
+	ReconnectResetInterval time.Duration
+	// 批量发送窗口，大于0时写协程收集窗口内到达的消息后整批发送，整批消息先写入缓冲区再一次写入连接，
+	// 减少大量小消息的系统调用次数；缓冲在连接建立时包装，修改后对下次连接生效
+	WriteBatchWindow time.Duration
+	// 严格保序，开启后忽略消息的优先级，所有消息进入同一个先进先出的缓冲通道，入队（包括DropOldest的丢弃和放入、
+	// Block的等待）通过互斥锁串行化，同一协程内发送的消息在连接上的顺序与调用顺序严格一致；
+	// 多个协程并发发送时，不同协程之间的顺序由获取锁的先后决定，调用方需自行同步才能保证全局顺序
+	StrictOrdering bool
+	// 保持混合发送的顺序，StrictOrdering的别名，开启任一效果相同：Text、Binary和高优先级消息交替发送时，
+	// 连接上的顺序与入队顺序完全一致，PriorityHigh不再越过先入队的消息
+	PreserveMixedOrder bool
+	// 握手请求的Origin头，用于校验来源的服务端
+	Origin string
+	// 握手请求的额外请求头，每次拨号时合并到RequestHeader，同名时覆盖RequestHeader
+	Headers map[string]string
+	// 每次拨号时根据ctx生成额外的请求头，如链路追踪的traceparent/tracestate，重连时使用context.Background()
+	HeaderFunc func(ctx context.Context) http.Header
+	// 流式读取，开启且注册了OnMessageStream时不再完整缓存每条消息，而是将io.Reader交给回调边读边处理，
+	// 回调返回后读协程才会读取下一条消息，未读完的数据将被丢弃
+	StreamReads bool
+	// 自定义拨号函数，可用于Unix socket、内存管道等非TCP传输
+	NetDial func(network, addr string) (net.Conn, error)
+	// 自定义带ctx的拨号函数，同时设置时优先于NetDial
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// 备用连接地址，连接失败时依次尝试主地址和备用地址，全部失败后才按退避策略等待
+	FallbackURLs []string
+	// Cookie容器，握手时携带其中的Cookie并保存服务端设置的Cookie，重连时继续使用，可用于基于会话Cookie鉴权的服务端
+	CookieJar http.CookieJar
+	// 读缓冲区大小，0表示使用Dialer的设置
+	ReadBufferSize int
+	// 写缓冲区大小，0表示使用Dialer的设置
+	WriteBufferSize int
+	// 写缓冲区池，大量客户端共享同一个池时空闲连接不再各自持有写缓冲区，减少内存占用
+	WriteBufferPool websocket.BufferPool
+	// 协商了压缩扩展时压缩的消息最小长度，更短的消息压缩收益低甚至变长，直接发送；
+	// 0表示使用DefaultCompressionMinSize，小于0表示压缩所有消息，流式发送和预先编码的消息不受限制
+	CompressionMinSize int
+	// 协程池，用于运行读写协程和重连，为nil时直接开启协程；读写协程和重连提交失败时回调OnConnectError后直接开启协程
+	Pool Pool
+	// 异步执行接收消息回调，开启后每条消息的回调提交到协程池执行，耗时的回调不会阻塞读协程导致读超时，
+	// 回调之间不保证顺序，提交失败时在读协程中直接执行
+	AsyncCallbacks bool
+	// 开启AsyncCallbacks时每个连接同时执行的回调数量上限，达到上限时读协程阻塞等待，
+	// 不再读取连接上的数据，从而对服务端形成背压；0表示不限制
+	MaxConcurrentCallbacks int
+	// 异步执行ping、pong回调，开启后回调提交到协程池执行，耗时的回调不会阻塞读协程，回复pong等协议处理仍同步完成
+	AsyncControlCallbacks bool
+	// 按序异步执行接收消息回调的队列长度，大于0时每个连接使用一个专门的协程按接收顺序执行回调，
+	// 优先于AsyncCallbacks；队列已满时丢弃消息并触发OnCallbackOverflow
+	CallbackQueueSize int
+	// 从收到的消息中提取请求id，用于Request匹配响应，消息不是响应时返回false
+	IDExtractor func(message []byte) (id string, ok bool)
+	// 捕获回调中的panic，开启后回调panic时触发OnCallbackPanic，读写协程和重连继续运行，默认开启
+	RecoverCallbacks bool
+	// 心跳定时器和重连等待使用的时钟，为nil时使用系统时钟，测试时可替换为wsctest.FakeClock等可控的时钟
+	Clock Clock
+}
+
+// Clock 时钟，心跳定时器和重连等待通过时钟计时
+type Clock interface {
+	// Now 返回当前时间
+	Now() time.Time
+	// NewTicker 创建周期为d的定时器
+	NewTicker(d time.Duration) Ticker
+	// After 返回d之后收到当前时间的通道
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker 周期定时器
+type Ticker interface {
+	// C 返回接收触发时间的通道
+	C() <-chan time.Time
+	// Reset 停止定时器并按新的周期d重新开始
+	Reset(d time.Duration)
+	// Stop 停止定时器
+	Stop()
+}
+
+// realClock 系统时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// realTicker 系统定时器
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// clock 返回实际使用的时钟
+func (c *Config) clock() Clock {
+	if c.Clock == nil {
+		return realClock{}
+	}
+	return c.Clock
+}
+
+// OverflowPolicy 缓冲通道已满时的处理策略
+type OverflowPolicy int
+
+const (
+	// DropNewest 丢弃新消息并返回ErrBuffer
+	DropNewest OverflowPolicy = iota
+	// DropOldest 丢弃缓冲通道中最早的消息后放入新消息，适合只关心最新数据的场景
+	DropOldest
+	// Block 阻塞等待缓冲通道有空位，连接断开时返回，开始等待前触发一次OnBufferFull
+	Block
+)
+
+// Pool 协程池，ants.Pool等实现可直接使用
+type Pool interface {
+	// Submit 提交任务，协程池已满等情况返回错误
+	Submit(task func()) error
+}
+
+// fillDefaults 使用默认值填充零值字段
+func (c *Config) fillDefaults() {
+	d := defaultConfig()
+	if c.WriteWait == 0 {
+		c.WriteWait = d.WriteWait
+	}
+	if c.MaxMessageSize == 0 {
+		c.MaxMessageSize = d.MaxMessageSize
+	}
+	if c.MinRecTime == 0 {
+		c.MinRecTime = d.MinRecTime
+	}
+	if c.MaxRecTime == 0 {
+		c.MaxRecTime = d.MaxRecTime
+	}
+	if c.RecFactor == 0 {
+		c.RecFactor = d.RecFactor
+	}
+	if c.MessageBufferSize == 0 {
+		c.MessageBufferSize = d.MessageBufferSize
+	}
+	if c.KeepaliveTime == 0 {
+		c.KeepaliveTime = d.KeepaliveTime
+	}
+}
+
+// Validate 检查配置是否合法，返回包含所有问题的*ConfigError，errors.Is(err, ErrInvalidConfig)成立
+func (c *Config) Validate() error {
+	var problems []string
+	if c.WriteWait < 0 {
+		problems = append(problems, fmt.Sprintf("WriteWait %v is negative", c.WriteWait))
+	}
+	if c.MinRecTime < 0 {
+		problems = append(problems, fmt.Sprintf("MinRecTime %v is negative", c.MinRecTime))
+	}
+	if c.MaxRecTime < c.MinRecTime {
+		problems = append(problems, fmt.Sprintf("MaxRecTime %v is less than MinRecTime %v", c.MaxRecTime, c.MinRecTime))
+	}
+	if c.RecFactor <= 1 {
+		problems = append(problems, fmt.Sprintf("RecFactor %v must be greater than 1", c.RecFactor))
+	}
+	if c.MessageBufferSize <= 0 {
+		problems = append(problems, fmt.Sprintf("MessageBufferSize %d must be positive", c.MessageBufferSize))
+	}
+	if c.KeepaliveTime <= 0 && !c.DisableKeepalive {
+		problems = append(problems, fmt.Sprintf("KeepaliveTime %v must be positive", c.KeepaliveTime))
+	}
+	if c.HeartbeatType != 0 && c.HeartbeatType != websocket.TextMessage && c.HeartbeatType != websocket.BinaryMessage {
+		problems = append(problems, fmt.Sprintf("HeartbeatType %d must be TextMessage or BinaryMessage", c.HeartbeatType))
+	}
+	if c.OverflowPolicy < DropNewest || c.OverflowPolicy > Block {
+		problems = append(problems, fmt.Sprintf("OverflowPolicy %d is unknown", c.OverflowPolicy))
+	}
+	if c.SendRateLimit < 0 {
+		problems = append(problems, fmt.Sprintf("SendRateLimit %v is negative", c.SendRateLimit))
+	}
+	if c.MaxIdleTime < 0 {
+		problems = append(problems, fmt.Sprintf("MaxIdleTime %v is negative", c.MaxIdleTime))
+	}
+	if c.CloseGracePeriod < 0 {
+		problems = append(problems, fmt.Sprintf("CloseGracePeriod %v is negative", c.CloseGracePeriod))
+	}
+	if c.ReconnectResetInterval < 0 {
+		problems = append(problems, fmt.Sprintf("ReconnectResetInterval %v is negative", c.ReconnectResetInterval))
+	}
+	if c.ReadBufferSize < 0 {
+		problems = append(problems, fmt.Sprintf("ReadBufferSize %d is negative", c.ReadBufferSize))
+	}
+	if c.WriteBufferSize < 0 {
+		problems = append(problems, fmt.Sprintf("WriteBufferSize %d is negative", c.WriteBufferSize))
+	}
+	if c.MaxConcurrentCallbacks < 0 {
+		problems = append(problems, fmt.Sprintf("MaxConcurrentCallbacks %d is negative", c.MaxConcurrentCallbacks))
+	}
+	if c.CallbackQueueSize < 0 {
+		problems = append(problems, fmt.Sprintf("CallbackQueueSize %d is negative", c.CallbackQueueSize))
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// ConfigError 配置不合法，包含所有问题
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return ErrInvalidConfig.Error() + ": " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ConfigError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// strictOrdering 是否严格保序，PreserveMixedOrder是StrictOrdering的别名
+func (c *Config) strictOrdering() bool {
+	return c.StrictOrdering || c.PreserveMixedOrder
+}
+
+// closeTimeout 返回实际生效的断开底层连接的最长等待时间
+func (c *Config) closeTimeout() time.Duration {
+	if c.CloseTimeout <= 0 {
+		return DefaultCloseTimeout
+	}
+	return c.CloseTimeout
+}
+
+// compress 长度为size的消息是否需要压缩
+func (c *Config) compress(size int) bool {
+	switch {
+	case c.CompressionMinSize < 0:
+		return true
+	case c.CompressionMinSize == 0:
+		return size >= DefaultCompressionMinSize
+	}
+	return size >= c.CompressionMinSize
+}
+
+// readLimit 返回实际生效的消息最大长度，避免0值导致不限制长度
+func (c *Config) readLimit() int64 {
+	if c.MaxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return c.MaxMessageSize
 }
 
 type WebSocket struct {
@@ -77,337 +608,2674 @@ type WebSocket struct {
 	HttpResponse  *http.Response
 	// 是否已连接
 	isConnected bool
+	// 是否正在连接，连接成功或放弃连接时重置
+	dialing bool
+	// 连接成功时关闭，断开后重新创建，用于等待连接
+	connected chan struct{}
+	// 有调用方等待下一次连接时不为nil，下一次连接完成后关闭
+	reconnected chan struct{}
 	// 加锁避免重复关闭管道
 	connMu *sync.RWMutex
 	// 发送消息锁
 	sendMu *sync.Mutex
-	// 发送消息缓冲池
+	// 严格保序时的入队锁
+	enqueueMu *sync.Mutex
+	// 发送消息缓冲池，断开时不关闭而是关闭done通知写协程退出，并发发送的调用方不会因写入已关闭的通道而panic，
+	// 断开后仍写入旧通道的消息随旧通道一起丢弃
 	sendChan chan *wsMsg
+	// 高优先级消息缓冲池，写协程优先发送
+	prioChan chan *wsMsg
+	// 连接断开时关闭，通知写协程退出
+	done chan struct{}
+	// 正在优雅关闭，不再接受新消息
+	closing bool
+	// 暂停发送时不为nil，恢复时关闭
+	paused chan struct{}
+	// 最后一次断开的原因
+	lastClose *ClosedError
+	// 最近一次连接成功的时间
+	connectedAt time.Time
+	// 最近一次连接成功的地址
+	connectedURL string
+	// 连接状态
+	state State
+	// 主动关闭等待服务端回复关闭帧时不为nil，读协程退出时关闭
+	closeAcked chan struct{}
+	// 开启PersistPending时断线保存的未发送消息，下次连接成功后重新入队
+	replay []*wsMsg
+	// 当前连接所属的生命周期，断线重连时沿用，主动关闭后不再重连
+	life context.Context
+}
+
+// State 连接状态
+type State int
+
+const (
+	// Disconnected 未连接，尚未发起连接或断开后不再重连
+	Disconnected State = iota
+	// Connecting 首次连接中
+	Connecting
+	// Connected 已连接
+	Connected
+	// Reconnecting 断线后重连中
+	Reconnecting
+	// Closing 主动关闭中
+	Closing
+	// Closed 已主动关闭
+	Closed
+)
+
+func (s State) String() string {
+	switch s {
+	case Disconnected:
+		return "Disconnected"
+	case Connecting:
+		return "Connecting"
+	case Connected:
+		return "Connected"
+	case Reconnecting:
+		return "Reconnecting"
+	case Closing:
+		return "Closing"
+	case Closed:
+		return "Closed"
+	}
+	return "State(" + strconv.Itoa(int(s)) + ")"
 }
 
 type wsMsg struct {
 	t   int
 	msg []byte
+	// 过期时间，零值表示永不过期
+	expireAt time.Time
+	// 写超时，零值表示使用Config.WriteWait
+	writeWait time.Duration
+	// 标记消息，写协程处理到该消息时关闭，用于等待之前的消息发送完成
+	flushed chan struct{}
+	// 流式发送的数据源，不为nil时忽略msg
+	reader io.Reader
+	// 流式发送的分块大小，大于0时按块写入并回调发送进度
+	chunkSize int
+	// 预先编码的消息，不为nil时忽略msg
+	prepared *websocket.PreparedMessage
+	// 优先级
+	priority Priority
+	// 本条消息不压缩，仅在握手协商了压缩扩展时有意义
+	noCompression bool
+	// 序列号，开启SequenceTags时入队分配，0表示不添加标记
+	seq uint64
+}
+
+// Priority 消息优先级
+type Priority int
+
+const (
+	// PriorityNormal 普通消息，按发送顺序进入缓冲通道
+	PriorityNormal Priority = iota
+	// PriorityHigh 高优先级消息，进入单独的缓冲通道，写协程优先发送，适合取消订阅、刷新鉴权等关键消息
+	PriorityHigh
+)
+
+// expired 消息是否已过期
+func (m *wsMsg) expired() bool {
+	return !m.expireAt.IsZero() && time.Now().After(m.expireAt)
 }
 
 // New 创建一个Wsc客户端
 func New(url string) *Wsc {
-	return &Wsc{
-		Config: &Config{
-			WriteWait:         10 * time.Second,
-			MaxMessageSize:    10 * 1024 * 1024,
-			MinRecTime:        2 * time.Second,
-			MaxRecTime:        60 * time.Second,
-			RecFactor:         1.5,
-			MessageBufferSize: 256,
-			KeepaliveTime:     300,
-			EnableReconnect:   true,
-		},
+	wsc := &Wsc{
+		Config:         defaultConfig(),
+		keepaliveReset: make(chan struct{}, 1),
+		reconnectKick:  make(chan struct{}, 1),
 		WebSocket: &WebSocket{
 			Url:           url,
 			Dialer:        websocket.DefaultDialer,
 			RequestHeader: http.Header{},
 			isConnected:   false,
+			connected:     make(chan struct{}),
 			connMu:        &sync.RWMutex{},
 			sendMu:        &sync.Mutex{},
+			enqueueMu:     &sync.Mutex{},
 		},
 	}
+	return wsc
 }
 
-func (wsc *Wsc) SetConfig(config *Config) {
-	wsc.Config = config
+// NewWithConfig 使用自定义配置创建一个Wsc客户端，配置中的零值字段使用默认值填充，
+// EnableReconnect、ReconnectJitter等布尔字段按原值使用，配置不合法时返回错误，cfg本身不会被修改；cfg为nil时使用默认配置
+func NewWithConfig(url string, cfg *Config) (*Wsc, error) {
+	if cfg == nil {
+		return New(url), nil
+	}
+	config := *cfg
+	config.fillDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	wsc := New(url)
+	wsc.Config = &config
+	return wsc, nil
 }
 
-func (wsc *Wsc) OnConnected(f func()) {
-	wsc.onConnected = f
+// defaultConfig 返回默认配置
+func defaultConfig() *Config {
+	return &Config{
+		WriteWait:         10 * time.Second,
+		MaxMessageSize:    DefaultMaxMessageSize,
+		MinRecTime:        2 * time.Second,
+		MaxRecTime:        60 * time.Second,
+		RecFactor:         1.5,
+		MessageBufferSize: 256,
+		KeepaliveTime:     300 * time.Second,
+		EnableReconnect:   true,
+		ReconnectJitter:   true,
+		RecoverCallbacks:  true,
+	}
 }
 
-func (wsc *Wsc) OnConnectError(f func(err error)) {
-	wsc.onConnectError = f
+// SetConfig 整体替换配置，连接后调用也是安全的，新的心跳间隔立即生效，其余配置在下次读取时生效；
+// 配置不合法时返回错误且不替换，替换后不应再修改config的字段
+func (wsc *Wsc) SetConfig(config *Config) error {
+	if config == nil {
+		return &ConfigError{Problems: []string{"config is nil"}}
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	wsc.configMu.Lock()
+	wsc.config.Store(&appliedConfig{field: wsc.Config, config: config})
+	wsc.configMu.Unlock()
+	wsc.resetKeepalive()
+	return nil
 }
 
-func (wsc *Wsc) OnDisconnected(f func(err error)) {
-	wsc.onDisconnected = f
+// SetKeepaliveInterval 修改心跳间隔，连接中时立即重置心跳定时器，d不为正数时返回错误，关闭心跳应使用DisableKeepalive
+func (wsc *Wsc) SetKeepaliveInterval(d time.Duration) error {
+	if d <= 0 {
+		return &ConfigError{Problems: []string{fmt.Sprintf("KeepaliveTime %v must be positive", d)}}
+	}
+	wsc.updateConfig(func(c *Config) { c.KeepaliveTime = d })
+	wsc.resetKeepalive()
+	return nil
 }
 
-func (wsc *Wsc) OnClose(f func(code int, text string)) {
-	wsc.onClose = f
+// SetWriteWait 修改写超时，对之后写入的消息生效
+func (wsc *Wsc) SetWriteWait(d time.Duration) {
+	wsc.updateConfig(func(c *Config) { c.WriteWait = d })
 }
 
-func (wsc *Wsc) OnTextMessageSent(f func(message []byte)) {
-	wsc.onTextMessageSent = f
+// appliedConfig 通过方法修改后生效的配置
+type appliedConfig struct {
+	// 修改时Config字段的值，Config字段被整体赋值后与之不同，修改随之失效
+	field  *Config
+	config *Config
 }
 
-func (wsc *Wsc) OnBinaryMessageSent(f func(data []byte)) {
-	wsc.onBinaryMessageSent = f
+// cfg 返回当前生效的配置，未通过方法修改或Config字段在修改后被整体赋值时返回Config字段
+func (wsc *Wsc) cfg() *Config {
+	if a, ok := wsc.config.Load().(*appliedConfig); ok && a.field == wsc.Config {
+		return a.config
+	}
+	return wsc.Config
 }
 
-func (wsc *Wsc) OnSentError(f func(err error)) {
-	wsc.onSentError = f
+// updateConfig 复制当前配置，修改后整体替换
+func (wsc *Wsc) updateConfig(set func(c *Config)) {
+	wsc.configMu.Lock()
+	defer wsc.configMu.Unlock()
+	c := *wsc.cfg()
+	set(&c)
+	wsc.config.Store(&appliedConfig{field: wsc.Config, config: &c})
 }
 
-func (wsc *Wsc) OnPingReceived(f func(appData string)) {
-	wsc.onPingReceived = f
+// resetKeepalive 通知写协程按新的心跳间隔重置定时器，已有未处理的通知时无需重复通知
+func (wsc *Wsc) resetKeepalive() {
+	select {
+	case wsc.keepaliveReset <- struct{}{}:
+	default:
+	}
 }
 
-func (wsc *Wsc) OnPongReceived(f func(appData string)) {
-	wsc.onPongReceived = f
+// closed 触发连接关闭回调
+func (wsc *Wsc) closed(code int, text string, byClient bool) {
+	cb := wsc.cb()
+	if cb.onClose != nil {
+		wsc.safe(func() { cb.onClose(code, text) })
+	}
+	if cb.onCloseDetailed != nil {
+		wsc.safe(func() { cb.onCloseDetailed(code, text, byClient) })
+	}
 }
 
-func (wsc *Wsc) OnTextMessageReceived(f func(message []byte)) {
-	wsc.onTextMessageReceived = f
+// setCallback 复制当前回调集合，修改后整体替换，可在连接后的任意时刻注册回调
+func (wsc *Wsc) setCallback(set func(cb *callbacks)) {
+	wsc.callbacksMu.Lock()
+	defer wsc.callbacksMu.Unlock()
+	next := *wsc.cb()
+	set(&next)
+	wsc.callbacks.Store(&next)
 }
 
-func (wsc *Wsc) OnBinaryMessageReceived(f func(data []byte)) {
-	wsc.onBinaryMessageReceived = f
+// cb 返回当前回调集合
+func (wsc *Wsc) cb() *callbacks {
+	if cb, ok := wsc.callbacks.Load().(*callbacks); ok {
+		return cb
+	}
+	return &callbacks{}
 }
 
-func (wsc *Wsc) OnKeepalive(f func()) {
-	wsc.onKeepalive = f
+// reportConnectError 连接异常回调
+func (wsc *Wsc) reportConnectError(err error) {
+	if f := wsc.cb().onConnectError; f != nil {
+		wsc.safe(func() { f(err) })
+	}
 }
 
-// IsConnected 返回连接状态
-func (wsc *Wsc) IsConnected() bool {
-	wsc.WebSocket.connMu.RLock()
-	defer wsc.WebSocket.connMu.RUnlock()
-	return wsc.WebSocket.isConnected
+// safe 执行回调，开启RecoverCallbacks时回调中的panic不会传播到读写协程和重连协程
+func (wsc *Wsc) safe(f func()) {
+	defer wsc.recoverCallback()
+	f()
 }
 
-// Connect 发起连接
-func (wsc *Wsc) Connect() {
-	wsc.WebSocket.sendChan = make(chan *wsMsg, wsc.Config.MessageBufferSize) // 缓冲
-	b := &backoff.Backoff{
-		Min:    wsc.Config.MinRecTime,
-		Max:    wsc.Config.MaxRecTime,
-		Factor: wsc.Config.RecFactor,
-		Jitter: true,
-	}
-	for {
-		var err error
-		nextRec := b.Duration()
-		wsc.WebSocket.Conn, wsc.WebSocket.HttpResponse, err =
-			wsc.WebSocket.Dialer.Dial(wsc.WebSocket.Url, wsc.WebSocket.RequestHeader)
-		if err != nil {
-			if wsc.onConnectError != nil {
-				wsc.onConnectError(err)
-			}
-			// 重试
-			time.Sleep(nextRec)
-			continue
-		}
-		// 变更连接状态
-		wsc.WebSocket.connMu.Lock()
-		wsc.WebSocket.isConnected = true
-		wsc.WebSocket.connMu.Unlock()
-		// 连接成功回调
-		if wsc.onConnected != nil {
-			wsc.onConnected()
-		}
-		// 设置支持接受的消息最大长度
-		wsc.WebSocket.Conn.SetReadLimit(wsc.Config.MaxMessageSize)
-		// 收到连接关闭信号回调
-		defaultCloseHandler := wsc.WebSocket.Conn.CloseHandler()
-		wsc.WebSocket.Conn.SetCloseHandler(func(code int, text string) error {
-			result := defaultCloseHandler(code, text)
-			wsc.clean()
-			if wsc.onClose != nil {
-				wsc.onClose(code, text)
-			}
-			return result
-		})
-		// 收到ping回调
-		defaultPingHandler := wsc.WebSocket.Conn.PingHandler()
-		wsc.WebSocket.Conn.SetPingHandler(func(appData string) error {
-			if wsc.onPingReceived != nil {
-				wsc.onPingReceived(appData)
-			}
-			return defaultPingHandler(appData)
-		})
-		// 收到pong回调
-		defaultPongHandler := wsc.WebSocket.Conn.PongHandler()
-		wsc.WebSocket.Conn.SetPongHandler(func(appData string) error {
-			if wsc.onPongReceived != nil {
-				wsc.onPongReceived(appData)
-			}
-			return defaultPongHandler(appData)
-		})
-		// 开启协程读
-		go wsc.writeLoop()
-		// 开启协程写
-		go wsc.readLoop()
-
+// recoverCallback 开启RecoverCallbacks时捕获panic并触发OnCallbackPanic，需直接通过defer调用
+func (wsc *Wsc) recoverCallback() {
+	if !wsc.cfg().RecoverCallbacks {
 		return
 	}
+	if r := recover(); r != nil {
+		wsc.reportPanic(r)
+	}
 }
 
-// readLoop 消息读取
-func (wsc *Wsc) readLoop() {
-	for {
-		messageType, message, err := wsc.WebSocket.Conn.ReadMessage()
-		if err != nil {
-			// 异常断线重连
-			if wsc.onDisconnected != nil {
-				wsc.onDisconnected(err)
-			}
-			wsc.closeAndRecConn()
-			return
-		}
-		switch messageType {
-		// 收到TextMessage回调
-		case websocket.TextMessage:
-			if wsc.onTextMessageReceived != nil {
-				wsc.onTextMessageReceived(message)
-			}
-		// 收到BinaryMessage回调
-		case websocket.BinaryMessage:
-			if wsc.onBinaryMessageReceived != nil {
-				wsc.onBinaryMessageReceived(message)
-			}
-		}
+// reportPanic 触发OnCallbackPanic，需在recover所在的defer中调用，调用栈才包含panic的位置
+func (wsc *Wsc) reportPanic(r interface{}) {
+	if f := wsc.cb().onCallbackPanic; f != nil {
+		f(r, debug.Stack())
 	}
 }
 
-// writeLoop 消息发送
-func (wsc *Wsc) writeLoop() {
-	keepaliveTick := time.NewTicker(wsc.Config.KeepaliveTime * time.Second)
-	for {
-		select {
-		case wsMsg, ok := <-wsc.WebSocket.sendChan:
-			if !ok {
-				return
-			}
-			err := wsc.send(wsMsg.t, wsMsg.msg)
-			if err != nil {
-				if wsc.onSentError != nil {
-					wsc.onSentError(err)
-				}
-				continue
-			}
-			switch wsMsg.t {
-			case websocket.CloseMessage:
-				return
-			case websocket.TextMessage:
-				if wsc.onTextMessageSent != nil {
-					wsc.onTextMessageSent(wsMsg.msg)
-				}
-			case websocket.BinaryMessage:
-				if wsc.onBinaryMessageSent != nil {
-					wsc.onBinaryMessageSent(wsMsg.msg)
-				}
-			}
-		case <-keepaliveTick.C:
-			wsc.WebSocket.Conn.WriteMessage(websocket.PingMessage, nil)
-			if wsc.onKeepalive != nil {
-				wsc.onKeepalive()
-			}
-		}
+func (wsc *Wsc) OnStateChange(f func(old, new State)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onStateChange = f })
+}
 
-	}
+func (wsc *Wsc) OnConnecting(f func(url string, attempt int)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onConnecting = f })
 }
 
-// SendTextMessage 发送TextMessage消息
-func (wsc *Wsc) SendTextMessage(message string) error {
-	if !wsc.IsConnected() {
-		return ErrClose
-	}
-	// 丢入缓冲通道处理
-	select {
-	case wsc.WebSocket.sendChan <- &wsMsg{
-		t:   websocket.TextMessage,
-		msg: []byte(message),
-	}:
-	default:
-		return ErrBuffer
-	}
-	return nil
+func (wsc *Wsc) OnConnected(f func()) {
+	wsc.setCallback(func(cb *callbacks) { cb.onConnected = f })
 }
 
-// SendTextMessage 发送TextMessage消息
-func (wsc *Wsc) SendByteMessage(message []byte) error {
-	if !wsc.IsConnected() {
-		return ErrClose
-	}
-	// 丢入缓冲通道处理
-	select {
-	case wsc.WebSocket.sendChan <- &wsMsg{
-		t:   websocket.TextMessage,
-		msg: message,
-	}:
-	default:
-		return ErrBuffer
-	}
-	return nil
+func (wsc *Wsc) OnConnectedResponse(f func(resp *http.Response)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onConnectedResponse = f })
 }
 
-// SendBinaryMessage 发送BinaryMessage消息
-func (wsc *Wsc) SendBinaryMessage(data []byte) error {
-	if !wsc.IsConnected() {
-		return ErrClose
-	}
-	// 丢入缓冲通道处理
-	select {
-	case wsc.WebSocket.sendChan <- &wsMsg{
-		t:   websocket.BinaryMessage,
-		msg: data,
-	}:
-	default:
-		return ErrBuffer
-	}
-	return nil
+func (wsc *Wsc) OnMessageTooBig(f func(limit int64)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onMessageTooBig = f })
 }
 
-// send 发送消息到连接端
-func (wsc *Wsc) send(messageType int, data []byte) error {
-	wsc.WebSocket.sendMu.Lock()
-	defer wsc.WebSocket.sendMu.Unlock()
-	if !wsc.IsConnected() {
-		return ErrClose
-	}
-	// 超时时间
-	deadline := time.Now().Add(wsc.Config.WriteWait)
-	if err := wsc.WebSocket.Conn.SetWriteDeadline(deadline); err != nil {
-		return err
-	}
-	return wsc.WebSocket.Conn.WriteMessage(messageType, data)
+func (wsc *Wsc) OnReceiveError(f func(err error)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onReceiveError = f })
 }
 
-// closeAndRecConn 断线重连
-func (wsc *Wsc) closeAndRecConn() {
-	if !wsc.IsConnected() {
-		return
-	}
-	wsc.clean()
-	if wsc.Config.EnableReconnect {
-		go wsc.Connect()
-	}
+// Use 注册消息拦截器，outbound在写入连接前处理Text/Binary消息，返回错误时放弃发送并触发OnSentError；
+// inbound在读出消息后、分发到回调前处理，返回错误时丢弃消息并触发OnReceiveError。
+// 多次注册时outbound按注册顺序执行，inbound按注册的逆序执行，成对注册的加解密等拦截器可正确嵌套；
+// 任一参数可为nil，流式发送和流式读取的消息不经过拦截器
+func (wsc *Wsc) Use(outbound, inbound func(data []byte) ([]byte, error)) {
+	wsc.setCallback(func(cb *callbacks) {
+		if outbound != nil {
+			cb.outbound = append(append([]func([]byte) ([]byte, error){}, cb.outbound...), outbound)
+		}
+		if inbound != nil {
+			cb.inbound = append([]func([]byte) ([]byte, error){inbound}, cb.inbound...)
+		}
+	})
 }
 
-// Close 主动关闭连接
-func (wsc *Wsc) Close() {
-	wsc.CloseWithMsg("")
+func (wsc *Wsc) OnConnectError(f func(err error)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onConnectError = f })
 }
 
-// CloseWithMsg 主动关闭连接，附带消息
-func (wsc *Wsc) CloseWithMsg(msg string) {
-	if !wsc.IsConnected() {
-		return
-	}
-	_ = wsc.send(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, msg))
-	wsc.clean()
-	if wsc.onClose != nil {
-		wsc.onClose(websocket.CloseNormalClosure, msg)
-	}
+func (wsc *Wsc) OnConnectErrorDecision(f func(err error, attempt int) bool) {
+	wsc.setCallback(func(cb *callbacks) { cb.onConnectErrorDecision = f })
 }
 
-// clean 清理资源
-func (wsc *Wsc) clean() {
-	if !wsc.IsConnected() {
+func (wsc *Wsc) OnBackoff(f func(attempt int, delay time.Duration, err error)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onBackoff = f })
+}
+
+func (wsc *Wsc) OnConnectErrorTimed(f func(err error, elapsed time.Duration, attempt int)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onConnectErrorTimed = f })
+}
+
+func (wsc *Wsc) OnDisconnected(f func(err error)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onDisconnected = f })
+}
+
+func (wsc *Wsc) OnRawFrame(f func(messageType int, data []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onRawFrame = f })
+}
+
+func (wsc *Wsc) OnCallbackPanic(f func(recovered interface{}, stack []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onCallbackPanic = f })
+}
+
+func (wsc *Wsc) OnSendProgress(f func(bytesSent int64)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onSendProgress = f })
+}
+
+func (wsc *Wsc) OnClose(f func(code int, text string)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onClose = f })
+}
+
+func (wsc *Wsc) OnCloseDetailed(f func(code int, text string, byClient bool)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onCloseDetailed = f })
+}
+
+func (wsc *Wsc) OnTextMessageSent(f func(message []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onTextMessageSent = f })
+}
+
+func (wsc *Wsc) OnBinaryMessageSent(f func(data []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onBinaryMessageSent = f })
+}
+
+func (wsc *Wsc) OnSentError(f func(err error)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onSentError = f })
+}
+
+func (wsc *Wsc) OnWriteTimeout(f func(message []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onWriteTimeout = f })
+}
+
+func (wsc *Wsc) OnPingReceived(f func(appData string)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onPingReceived = f })
+}
+
+func (wsc *Wsc) OnPongReceived(f func(appData string)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onPongReceived = f })
+}
+
+func (wsc *Wsc) OnTextMessageReceived(f func(message []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onTextMessageReceived = f })
+}
+
+func (wsc *Wsc) OnBinaryMessageReceived(f func(data []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onBinaryMessageReceived = f })
+}
+
+func (wsc *Wsc) OnTextMessageReceivedAt(f func(message []byte, readAt time.Time)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onTextMessageReceivedAt = f })
+}
+
+func (wsc *Wsc) OnBinaryMessageReceivedAt(f func(data []byte, readAt time.Time)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onBinaryMessageReceivedAt = f })
+}
+
+func (wsc *Wsc) OnMessage(f func(messageType int, data []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onMessage = f })
+}
+
+func (wsc *Wsc) OnKeepalive(f func()) {
+	wsc.setCallback(func(cb *callbacks) { cb.onKeepalive = f })
+}
+
+func (wsc *Wsc) OnMessageExpired(f func(message []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onMessageExpired = f })
+}
+
+func (wsc *Wsc) OnBufferFull(f func()) {
+	wsc.setCallback(func(cb *callbacks) { cb.onBufferFull = f })
+}
+
+func (wsc *Wsc) OnReplay(f func(count int)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onReplay = f })
+}
+
+func (wsc *Wsc) OnServerHeartbeat(f func()) {
+	wsc.setCallback(func(cb *callbacks) { cb.onServerHeartbeat = f })
+}
+
+func (wsc *Wsc) OnCallbackOverflow(f func(messageType int, data []byte)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onCallbackOverflow = f })
+}
+
+func (wsc *Wsc) OnMessageStream(f func(messageType int, r io.Reader)) {
+	wsc.setCallback(func(cb *callbacks) { cb.onMessageStream = f })
+}
+
+// lastCloseErr 返回连接已关闭的错误，携带最后一次断开的原因
+func (wsc *Wsc) lastCloseErr() error {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	if wsc.WebSocket.lastClose != nil {
+		return wsc.WebSocket.lastClose
+	}
+	return ErrClose
+}
+
+// Healthy 返回连接是否健康，已连接且配置了MaxIdleTime时要求该时间内收到过消息、ping或pong，
+// 可用于就绪和存活探针，比IsConnected更能发现半开连接
+func (wsc *Wsc) Healthy() bool {
+	if !wsc.IsConnected() {
+		return false
+	}
+	if wsc.cfg().MaxIdleTime <= 0 {
+		return true
+	}
+	last := atomic.LoadInt64(&wsc.lastReceived)
+	return time.Since(time.Unix(0, last)) <= wsc.cfg().MaxIdleTime
+}
+
+// IsConnected 返回连接状态
+func (wsc *Wsc) IsConnected() bool {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	return wsc.WebSocket.isConnected
+}
+
+// WaitConnected 阻塞直到连接成功或ctx结束
+func (wsc *Wsc) WaitConnected(ctx context.Context) error {
+	wsc.WebSocket.connMu.RLock()
+	connected := wsc.WebSocket.connected
+	wsc.WebSocket.connMu.RUnlock()
+	select {
+	case <-connected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AwaitReconnect 阻塞直到调用之后的下一次连接完成或ctx结束，已连接时等待断开后的重连，
+// 返回时OnConnected回调和重新订阅均已执行
+func (wsc *Wsc) AwaitReconnect(ctx context.Context) error {
+	wsc.WebSocket.connMu.Lock()
+	if wsc.WebSocket.reconnected == nil {
+		wsc.WebSocket.reconnected = make(chan struct{})
+	}
+	reconnected := wsc.WebSocket.reconnected
+	wsc.WebSocket.connMu.Unlock()
+	select {
+	case <-reconnected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resubscribe 注册重新订阅函数，首次连接和每次重连成功后按注册顺序执行，返回的错误通过OnConnectError回调
+func (wsc *Wsc) Resubscribe(f func() error) {
+	wsc.resubMu.Lock()
+	defer wsc.resubMu.Unlock()
+	wsc.resubscribes = append(wsc.resubscribes, f)
+}
+
+// resubscribe 执行所有重新订阅函数
+func (wsc *Wsc) resubscribe() {
+	wsc.resubMu.Lock()
+	resubscribes := wsc.resubscribes
+	wsc.resubMu.Unlock()
+	for _, f := range resubscribes {
+		var err error
+		wsc.safe(func() { err = f() })
+		if err != nil {
+			wsc.reportConnectError(err)
+		}
+	}
+}
+
+// SetDialer 替换拨号使用的Dialer，下次连接或重连时生效，可完全控制TLS、代理、子协议、压缩等选项，
+// 为nil时恢复为websocket.DefaultDialer；Config中的NetDial、NetDialContext、CookieJar依然会覆盖到Dialer的副本上
+func (wsc *Wsc) SetDialer(d *websocket.Dialer) {
+	if d == nil {
+		d = websocket.DefaultDialer
+	}
+	wsc.WebSocket.connMu.Lock()
+	wsc.WebSocket.Dialer = d
+	wsc.WebSocket.connMu.Unlock()
+}
+
+// dialer 返回本次拨号使用的Dialer，配置了自定义拨号函数、Cookie容器或缓冲区时基于WebSocket.Dialer复制一份，避免修改共享的默认Dialer
+func (wsc *Wsc) dialer() *websocket.Dialer {
+	wsc.WebSocket.connMu.RLock()
+	base := wsc.WebSocket.Dialer
+	wsc.WebSocket.connMu.RUnlock()
+	if wsc.cfg().NetDial == nil && wsc.cfg().NetDialContext == nil && wsc.cfg().CookieJar == nil &&
+		wsc.cfg().ReadBufferSize == 0 && wsc.cfg().WriteBufferSize == 0 && wsc.cfg().WriteBufferPool == nil {
+		return base
+	}
+	dialer := *base
+	if wsc.cfg().ReadBufferSize > 0 {
+		dialer.ReadBufferSize = wsc.cfg().ReadBufferSize
+	}
+	if wsc.cfg().WriteBufferSize > 0 {
+		dialer.WriteBufferSize = wsc.cfg().WriteBufferSize
+	}
+	if wsc.cfg().WriteBufferPool != nil {
+		dialer.WriteBufferPool = wsc.cfg().WriteBufferPool
+	}
+	if wsc.cfg().NetDial != nil {
+		dialer.NetDial = wsc.cfg().NetDial
+	}
+	if wsc.cfg().NetDialContext != nil {
+		dialer.NetDialContext = wsc.cfg().NetDialContext
+	}
+	if wsc.cfg().CookieJar != nil {
+		dialer.Jar = wsc.cfg().CookieJar
+	}
+	return &dialer
+}
+
+// requestHeader 合并RequestHeader、Headers、Origin和HeaderFunc生成的请求头，同名时以后者为准，不修改RequestHeader
+func (wsc *Wsc) requestHeader(ctx context.Context) http.Header {
+	if wsc.cfg().HeaderFunc == nil && len(wsc.cfg().Headers) == 0 && wsc.cfg().Origin == "" {
+		return wsc.WebSocket.RequestHeader
+	}
+	header := wsc.WebSocket.RequestHeader.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	for k, v := range wsc.cfg().Headers {
+		header.Set(k, v)
+	}
+	if wsc.cfg().Origin != "" {
+		header.Set("Origin", wsc.cfg().Origin)
+	}
+	if headerFunc := wsc.cfg().HeaderFunc; headerFunc != nil {
+		var extra http.Header
+		wsc.safe(func() { extra = headerFunc(ctx) })
+		for k, v := range extra {
+			header[k] = v
+		}
+	}
+	return header
+}
+
+// Messages 返回接收消息的通道，作为回调之外的拉取模式。
+// 仅当对应类型的接收回调未注册时，消息才会投递到该通道。
+// 通道在断线重连后依然有效，不会被关闭。
+// 通道缓冲大小为MessageBufferSize，消费过慢导致通道写满时读协程会阻塞，
+// 不再读取连接上的数据，从而对服务端形成背压。
+func (wsc *Wsc) Messages() <-chan Message {
+	wsc.messagesMu.Lock()
+	defer wsc.messagesMu.Unlock()
+	if wsc.messages == nil {
+		wsc.messages = make(chan Message, wsc.cfg().MessageBufferSize)
+	}
+	return wsc.messages
+}
+
+// pushMessage 投递消息到拉取通道，未调用过Messages时直接丢弃
+func (wsc *Wsc) pushMessage(messageType int, data []byte) {
+	wsc.messagesMu.Lock()
+	messages := wsc.messages
+	wsc.messagesMu.Unlock()
+	if messages == nil {
+		return
+	}
+	messages <- Message{Type: messageType, Data: data}
+}
+
+// Connect 发起连接，已连接或正在连接时不做任何操作
+func (wsc *Wsc) Connect() {
+	_ = wsc.ConnectContext(context.Background())
+}
+
+// ConnectContext 发起连接，配置不合法时直接返回错误，已连接或正在连接时返回ErrAlreadyConnected或ErrAlreadyConnecting，连接失败时依次尝试FallbackURLs，全部失败后按退避策略重试，ctx结束时停止重试并返回ctx的错误，
+// OnConnectErrorDecision回调返回false时停止重试并返回连接错误，期间调用Close时停止重试并返回context.Canceled
+func (wsc *Wsc) ConnectContext(ctx context.Context) error {
+	return wsc.connect(ctx, nil)
+}
+
+// ConnectWithTimeout 在d内建立连接，期间按退避策略重试，超时仍未连接时返回最后一次的连接错误，
+// 尚未完成任何一次尝试时返回context.DeadlineExceeded，适用于启动时连接失败即退出的服务
+func (wsc *Wsc) ConnectWithTimeout(d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	var lastErr error
+	err := wsc.connect(ctx, &lastErr)
+	if err == context.DeadlineExceeded && lastErr != nil {
+		return lastErr
+	}
+	return err
+}
+
+// connect 发起连接，lastErr不为nil时记录最后一次的连接错误
+func (wsc *Wsc) connect(ctx context.Context, lastErr *error) (err error) {
+	if err := wsc.cfg().Validate(); err != nil {
+		wsc.reportConnectError(err)
+		return err
+	}
+	// 同一时间只允许一个连接过程，避免多组读写协程共用缓冲通道
+	wsc.WebSocket.connMu.Lock()
+	if wsc.WebSocket.isConnected {
+		wsc.WebSocket.connMu.Unlock()
+		return ErrAlreadyConnected
+	}
+	if wsc.WebSocket.dialing {
+		wsc.WebSocket.connMu.Unlock()
+		return ErrAlreadyConnecting
+	}
+	wsc.WebSocket.dialing = true
+	// 断线重连时保持Reconnecting状态，放弃连接时回到Disconnected
+	reconnecting := wsc.WebSocket.state == Reconnecting
+	wsc.WebSocket.connMu.Unlock()
+	if !reconnecting {
+		wsc.setState(Connecting)
+	}
+	defer func() {
+		if err != nil {
+			wsc.WebSocket.connMu.Lock()
+			wsc.WebSocket.dialing = false
+			wsc.WebSocket.connMu.Unlock()
+			wsc.setState(Disconnected)
+		}
+	}()
+	// 生命周期结束时停止重试，调用方放弃等待后不会留下一直重连的协程
+	life := wsc.lifecycle()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-life.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	b := wsc.backoff()
+	for attempt := 1; ; attempt++ {
+		// 每次尝试都重新读取地址，连接过程中调用SetURL时下一次尝试即使用新地址
+		urls := wsc.urls()
+		url := urls[(attempt-1)%len(urls)]
+		if f := wsc.cb().onConnecting; f != nil {
+			wsc.safe(func() { f(url, attempt) })
+		}
+		dialAt := time.Now()
+		var bc *batchConn
+		conn, resp, err := wsc.batchDialer(wsc.dialer(), &bc).DialContext(ctx, url, wsc.requestHeader(ctx))
+		if err != nil {
+			elapsed := time.Since(dialAt)
+			wsc.WebSocket.connMu.Lock()
+			wsc.WebSocket.HttpResponse = resp
+			wsc.WebSocket.connMu.Unlock()
+			// 握手被服务端拒绝时携带HTTP响应
+			if resp != nil {
+				err = &HandshakeError{
+					StatusCode: resp.StatusCode,
+					Header:     resp.Header,
+					Response:   resp,
+					Err:        err,
+				}
+			}
+			if lastErr != nil {
+				*lastErr = err
+			}
+			wsc.reportConnectError(err)
+			if f := wsc.cb().onConnectErrorTimed; f != nil {
+				wsc.safe(func() { f(err, elapsed, attempt) })
+			}
+			atomic.StoreInt64(&wsc.reconnectAttempts, int64(attempt))
+			// 不可重试的错误，停止重连；决策回调panic时按默认继续重连
+			retry := true
+			if f := wsc.cb().onConnectErrorDecision; f != nil {
+				wsc.safe(func() { retry = f(err, attempt) })
+			}
+			if !retry {
+				return err
+			}
+			// 还有地址未尝试时立即尝试下一个地址
+			if attempt%len(urls) != 0 {
+				continue
+			}
+			nextRec := b.Duration()
+			// 服务端限流或不可用时，本次优先使用服务端建议的重试间隔
+			var handshakeErr *HandshakeError
+			if errors.As(err, &handshakeErr) &&
+				(handshakeErr.StatusCode == http.StatusTooManyRequests || handshakeErr.StatusCode == http.StatusServiceUnavailable) {
+				if retryAfter, ok := handshakeErr.RetryAfter(); ok {
+					nextRec = retryAfter
+				}
+			}
+			if f := wsc.cb().onBackoff; f != nil {
+				wsc.safe(func() { f(attempt, nextRec, err) })
+			}
+			// 重试
+			atomic.StoreInt64(&wsc.nextReconnectDelay, int64(nextRec))
+			select {
+			case <-wsc.cfg().clock().After(nextRec):
+				atomic.StoreInt64(&wsc.nextReconnectDelay, 0)
+			case <-wsc.reconnectKick:
+				// 调用了Reconnect，退避从MinRecTime重新开始
+				atomic.StoreInt64(&wsc.nextReconnectDelay, 0)
+				b.Reset()
+			case <-ctx.Done():
+				atomic.StoreInt64(&wsc.nextReconnectDelay, 0)
+				return ctx.Err()
+			}
+			continue
+		}
+		sendChan := make(chan *wsMsg, wsc.cfg().MessageBufferSize) // 缓冲
+		prioChan := make(chan *wsMsg, wsc.cfg().MessageBufferSize)
+		done := make(chan struct{})
+		// 变更连接状态
+		wsc.WebSocket.connMu.Lock()
+		// 拨号完成时ctx已结束，放弃本次连接，避免Stop之后仍留下读写协程
+		if ctx.Err() != nil {
+			wsc.WebSocket.connMu.Unlock()
+			_ = conn.Close()
+			return ctx.Err()
+		}
+		wsc.WebSocket.Conn = conn
+		wsc.WebSocket.HttpResponse = resp
+		wsc.WebSocket.sendChan = sendChan
+		wsc.WebSocket.prioChan = prioChan
+		wsc.WebSocket.done = done
+		wsc.WebSocket.closing = false
+		wsc.WebSocket.isConnected = true
+		wsc.WebSocket.dialing = false
+		wsc.WebSocket.connectedAt = time.Now()
+		wsc.WebSocket.connectedURL = url
+		wsc.WebSocket.life = life
+		replayed := wsc.WebSocket.requeue()
+		atomic.StoreInt64(&wsc.reconnectAttempts, 0)
+		// 连接已建立，丢弃拨号期间调用Reconnect留下的通知，避免之后的重连跳过等待
+		select {
+		case <-wsc.reconnectKick:
+		default:
+		}
+		atomic.StoreInt64(&wsc.nextReconnectDelay, 0)
+		close(wsc.WebSocket.connected)
+		wsc.WebSocket.connMu.Unlock()
+		// 连接成功回调
+		if reconnecting {
+			atomic.AddUint64(&wsc.stats.Reconnects, 1)
+		}
+		wsc.setState(Connected)
+		// 每个回调单独捕获panic，前一个回调panic不影响后续回调
+		cb := wsc.cb()
+		if cb.onConnected != nil {
+			wsc.safe(cb.onConnected)
+		}
+		if cb.onConnectedResponse != nil {
+			wsc.safe(func() { cb.onConnectedResponse(resp) })
+		}
+		if replayed > 0 && cb.onReplay != nil {
+			wsc.safe(func() { cb.onReplay(replayed) })
+		}
+		// 设置支持接受的消息最大长度
+		conn.SetReadLimit(wsc.readLimit())
+		// 收到连接关闭信号时由默认处理回复关闭帧，清理和关闭回调由readLoop统一处理，
+		// 手动处理时不回复，由调用方关闭连接时发送关闭帧
+		closeHandler := conn.CloseHandler()
+		if wsc.cfg().ManualCloseHandling {
+			closeHandler = func(code int, text string) error { return nil }
+		}
+		conn.SetCloseHandler(func(code int, text string) error {
+			if f := wsc.cb().onRawFrame; f != nil {
+				wsc.safe(func() { f(websocket.CloseMessage, websocket.FormatCloseMessage(code, text)) })
+			}
+			return closeHandler(code, text)
+		})
+		// 读到消息、ping和pong都说明连接存活
+		live := newLiveness(conn, wsc.cfg().ReadTimeout, &wsc.lastReceived)
+		// 收到ping回调
+		defaultPingHandler := conn.PingHandler()
+		conn.SetPingHandler(func(appData string) error {
+			live.alive(time.Now())
+			if f := wsc.cb().onRawFrame; f != nil {
+				wsc.safe(func() { f(websocket.PingMessage, []byte(appData)) })
+			}
+			if f := wsc.cb().onPingReceived; f != nil {
+				wsc.callControl(func() { f(appData) })
+			}
+			return defaultPingHandler(appData)
+		})
+		// 收到pong回调
+		defaultPongHandler := conn.PongHandler()
+		conn.SetPongHandler(func(appData string) error {
+			live.alive(time.Now())
+			if f := wsc.cb().onRawFrame; f != nil {
+				wsc.safe(func() { f(websocket.PongMessage, []byte(appData)) })
+			}
+			if f := wsc.cb().onPongReceived; f != nil {
+				wsc.callControl(func() { f(appData) })
+			}
+			return defaultPongHandler(appData)
+		})
+		// 开启协程写
+		wsc.mustSubmit(func() { wsc.writeLoop(conn, bc, sendChan, prioChan, done) })
+		// 开启协程读
+		live.alive(time.Now())
+		wsc.mustSubmit(func() { wsc.readLoop(conn, live) })
+		// 重新订阅
+		wsc.resubscribe()
+		// 通知等待下一次连接的调用方
+		wsc.WebSocket.connMu.Lock()
+		if wsc.WebSocket.reconnected != nil {
+			close(wsc.WebSocket.reconnected)
+			wsc.WebSocket.reconnected = nil
+		}
+		wsc.WebSocket.connMu.Unlock()
+
+		return nil
+	}
+}
+
+// ConnectedURL 返回最近一次连接成功的地址，配置了FallbackURLs时可用于判断当前连接的是哪个地址，从未连接时为空
+func (wsc *Wsc) ConnectedURL() string {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	return wsc.WebSocket.connectedURL
+}
+
+// LastHandshakeStatus 返回最近一次拨号的握手响应状态码，成功时为101，被服务端拒绝时为实际的状态码，
+// 未收到响应（如连接被拒绝、超时）或从未拨号时为0
+func (wsc *Wsc) LastHandshakeStatus() int {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	if wsc.WebSocket.HttpResponse == nil {
+		return 0
+	}
+	return wsc.WebSocket.HttpResponse.StatusCode
+}
+
+// LocalAddr 返回当前连接的本地地址，未连接时为nil
+func (wsc *Wsc) LocalAddr() net.Addr {
+	conn := wsc.currentConn()
+	if conn == nil {
+		return nil
+	}
+	return conn.LocalAddr()
+}
+
+// RemoteAddr 返回当前连接的远端地址，未连接时为nil
+func (wsc *Wsc) RemoteAddr() net.Addr {
+	conn := wsc.currentConn()
+	if conn == nil {
+		return nil
+	}
+	return conn.RemoteAddr()
+}
+
+// TLSConnectionState 返回当前连接协商的TLS状态，如TLS版本和加密套件，明文连接或未连接时返回false
+func (wsc *Wsc) TLSConnectionState() (tls.ConnectionState, bool) {
+	conn := wsc.currentConn()
+	if conn == nil {
+		return tls.ConnectionState{}, false
+	}
+	tlsConn, ok := conn.UnderlyingConn().(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tlsConn.ConnectionState(), true
+}
+
+// Stats 返回运行统计的快照
+func (wsc *Wsc) Stats() Stats {
+	return Stats{
+		MessagesSent:     atomic.LoadUint64(&wsc.stats.MessagesSent),
+		MessagesReceived: atomic.LoadUint64(&wsc.stats.MessagesReceived),
+		BytesSent:        atomic.LoadUint64(&wsc.stats.BytesSent),
+		BytesReceived:    atomic.LoadUint64(&wsc.stats.BytesReceived),
+		Reconnects:       atomic.LoadUint64(&wsc.stats.Reconnects),
+	}
+}
+
+// State 返回当前连接状态
+func (wsc *Wsc) State() State {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	return wsc.WebSocket.state
+}
+
+// setState 变更连接状态，状态变化时回调
+func (wsc *Wsc) setState(state State) {
+	wsc.WebSocket.connMu.Lock()
+	old := wsc.WebSocket.state
+	wsc.WebSocket.state = state
+	wsc.WebSocket.connMu.Unlock()
+	if f := wsc.cb().onStateChange; old != state && f != nil {
+		wsc.safe(func() { f(old, state) })
+	}
+}
+
+// SetReadLimit 调整支持接受的消息最大长度，之后重连也继续使用，用于握手后协商更大帧长度的协议，
+// 小于等于0时恢复使用配置的MaxMessageSize；gorilla的读取长度限制不能与读取并发修改，已连接时由读协程在开始读取下一条消息前设置，
+// 读协程已在等待时紧接着到达的一条消息仍按原长度检查；同步执行接收消息回调时在回调中调用可保证对下一条消息生效，
+// 开启AsyncCallbacks或CallbackQueueSize时回调与读取并发，不保证
+func (wsc *Wsc) SetReadLimit(n int64) {
+	atomic.StoreInt64(&wsc.maxMessageSize, n)
+}
+
+// readLimit 返回实际生效的消息最大长度
+func (wsc *Wsc) readLimit() int64 {
+	if n := atomic.LoadInt64(&wsc.maxMessageSize); n > 0 {
+		return n
+	}
+	return wsc.cfg().readLimit()
+}
+
+// ReconnectAttempts 返回当前连接过程中连续失败的次数，连接成功后为0，可用于展示重连状态
+func (wsc *Wsc) ReconnectAttempts() int {
+	return int(atomic.LoadInt64(&wsc.reconnectAttempts))
+}
+
+// NextReconnectDelay 返回正在等待的重连间隔，未在等待重连时为0
+func (wsc *Wsc) NextReconnectDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&wsc.nextReconnectDelay))
+}
+
+// readLoop 消息读取
+func (wsc *Wsc) readLoop(conn *websocket.Conn, live *liveness) {
+	queue := wsc.startDispatcher()
+	if queue != nil {
+		defer close(queue)
+	}
+	var sem chan struct{}
+	if wsc.cfg().MaxConcurrentCallbacks > 0 {
+		sem = make(chan struct{}, wsc.cfg().MaxConcurrentCallbacks)
+	}
+	limit := wsc.readLimit()
+	for {
+		// 运行时调整的最大长度从下一条消息开始生效，只在读协程内设置，避免与读取并发
+		if l := wsc.readLimit(); l != limit {
+			conn.SetReadLimit(l)
+			limit = l
+		}
+		var messageType int
+		var message []byte
+		var err error
+		// 流式读取时消息已在回调中处理，messageType为0不再分发
+		if onMessageStream := wsc.cb().onMessageStream; wsc.cfg().StreamReads && onMessageStream != nil {
+			err = wsc.readStream(conn, onMessageStream)
+		} else {
+			messageType, message, err = conn.ReadMessage()
+		}
+		// 读出后立即记录时间，不受回调处理耗时影响
+		readAt := time.Now()
+		if err != nil {
+			// 主动关闭时读到服务端的关闭回复，由关闭方负责清理
+			if wsc.ackClose(conn) {
+				return
+			}
+			wsc.closeAndRecConn(conn, err)
+			return
+		}
+		live.alive(readAt)
+		if f := wsc.cb().onRawFrame; f != nil && messageType != 0 {
+			wsc.safe(func() { f(messageType, message) })
+		}
+		if queue != nil {
+			wsc.enqueueCallback(queue, messageType, message, readAt)
+			continue
+		}
+		if wsc.cfg().AsyncCallbacks && wsc.dispatchAsync(sem, messageType, message, readAt) {
+			continue
+		}
+		wsc.dispatch(messageType, message, readAt)
+	}
+}
+
+// dispatchAsync 在协程池中分发消息，sem不为nil时限制同时执行的回调数量，已满时阻塞读协程，返回是否提交成功
+func (wsc *Wsc) dispatchAsync(sem chan struct{}, messageType int, message []byte, readAt time.Time) bool {
+	if sem != nil {
+		sem <- struct{}{}
+	}
+	err := wsc.submit(func() {
+		if sem != nil {
+			defer func() { <-sem }()
+		}
+		wsc.dispatch(messageType, message, readAt)
+	})
+	if err != nil && sem != nil {
+		<-sem
+	}
+	return err == nil
+}
+
+// callControl 执行ping、pong回调，开启AsyncControlCallbacks时提交到协程池，提交失败时直接执行
+func (wsc *Wsc) callControl(f func()) {
+	g := func() { wsc.safe(f) }
+	if wsc.cfg().AsyncControlCallbacks && wsc.submit(g) == nil {
+		return
+	}
+	g()
+}
+
+// startDispatcher 配置了CallbackQueueSize时启动按序执行回调的协程，返回其队列，读协程退出时关闭队列
+func (wsc *Wsc) startDispatcher() chan func() {
+	if wsc.cfg().CallbackQueueSize <= 0 {
+		return nil
+	}
+	queue := make(chan func(), wsc.cfg().CallbackQueueSize)
+	err := wsc.submit(func() {
+		for f := range queue {
+			f()
+		}
+	})
+	// 无法启动协程时退回到在读协程中执行回调
+	if err != nil {
+		return nil
+	}
+	return queue
+}
+
+// enqueueCallback 将消息的回调放入按序执行的队列，队列已满时丢弃
+func (wsc *Wsc) enqueueCallback(queue chan func(), messageType int, message []byte, readAt time.Time) {
+	select {
+	case queue <- func() { wsc.dispatch(messageType, message, readAt) }:
+	default:
+		if f := wsc.cb().onCallbackOverflow; f != nil {
+			wsc.safe(func() { f(messageType, message) })
+		}
+	}
+}
+
+// ackClose 正在等待服务端回复关闭帧时通知关闭方，返回是否已通知
+func (wsc *Wsc) ackClose(conn *websocket.Conn) bool {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	if wsc.WebSocket.closeAcked == nil || !wsc.WebSocket.isConnected || wsc.WebSocket.Conn != conn {
+		return false
+	}
+	close(wsc.WebSocket.closeAcked)
+	return true
+}
+
+// dispatch 分发收到的消息到回调
+func (wsc *Wsc) dispatch(messageType int, message []byte, readAt time.Time) {
+	// HeartbeatMatcher、IDExtractor等在此捕获，回调各自单独捕获，前一个回调panic不影响后续回调
+	defer wsc.recoverCallback()
+	if messageType != websocket.TextMessage && messageType != websocket.BinaryMessage {
+		return
+	}
+	atomic.AddUint64(&wsc.stats.MessagesReceived, 1)
+	atomic.AddUint64(&wsc.stats.BytesReceived, uint64(len(message)))
+	cb := wsc.cb()
+	message, err := wsc.intercept(cb.inbound, message)
+	if err != nil {
+		if cb.onReceiveError != nil {
+			wsc.safe(func() { cb.onReceiveError(err) })
+		}
+		return
+	}
+	// 服务端的应用层心跳，收到时已刷新读超时和存活时间
+	if wsc.cfg().HeartbeatMatcher != nil && wsc.cfg().HeartbeatMatcher(message) {
+		if cb.onServerHeartbeat != nil {
+			wsc.safe(func() { cb.onServerHeartbeat() })
+		}
+		if !wsc.cfg().PassHeartbeats {
+			return
+		}
+	}
+	// 请求的响应交给等待方，不再分发到回调
+	if wsc.resolve(message) {
+		return
+	}
+	// 有调用方在ReadMessage等待时交给调用方，不再分发到回调
+	if wsc.divert(messageType, message) {
+		return
+	}
+	// 所有数据帧回调，先于具体类型的回调触发
+	if cb.onMessage != nil {
+		wsc.safe(func() { cb.onMessage(messageType, message) })
+	}
+	switch messageType {
+	// 收到TextMessage回调
+	case websocket.TextMessage:
+		if cb.onTextMessageReceived != nil {
+			wsc.safe(func() { cb.onTextMessageReceived(message) })
+		}
+		if cb.onTextMessageReceivedAt != nil {
+			wsc.safe(func() { cb.onTextMessageReceivedAt(message, readAt) })
+		}
+		if cb.onTextMessageReceived == nil && cb.onTextMessageReceivedAt == nil {
+			wsc.pushMessage(messageType, message)
+		}
+	// 收到BinaryMessage回调
+	case websocket.BinaryMessage:
+		if cb.onBinaryMessageReceived != nil {
+			wsc.safe(func() { cb.onBinaryMessageReceived(message) })
+		}
+		if cb.onBinaryMessageReceivedAt != nil {
+			wsc.safe(func() { cb.onBinaryMessageReceivedAt(message, readAt) })
+		}
+		if cb.onBinaryMessageReceived == nil && cb.onBinaryMessageReceivedAt == nil {
+			wsc.pushMessage(messageType, message)
+		}
+	}
+}
+
+// Request 发送TextMessage请求并等待id相同的响应，响应通过Config.IDExtractor从收到的消息中匹配，
+// 匹配到的响应不会再分发到接收消息回调；ctx结束时返回ctx的错误，连接断开时返回断开原因
+func (wsc *Wsc) Request(ctx context.Context, id string, payload []byte) ([]byte, error) {
+	if wsc.cfg().IDExtractor == nil {
+		return nil, fmt.Errorf("%w: IDExtractor is required by Request", ErrInvalidConfig)
+	}
+	wsc.WebSocket.connMu.RLock()
+	connected, done := wsc.WebSocket.isConnected, wsc.WebSocket.done
+	wsc.WebSocket.connMu.RUnlock()
+	if !connected {
+		return nil, wsc.lastCloseErr()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req := &pendingRequest{resp: make(chan []byte, 1), cancel: cancel}
+	wsc.pendingMu.Lock()
+	if _, ok := wsc.pending[id]; ok {
+		wsc.pendingMu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrRequestPending, id)
+	}
+	if wsc.pending == nil {
+		wsc.pending = make(map[string]*pendingRequest)
+	}
+	wsc.pending[id] = req
+	wsc.pendingMu.Unlock()
+	defer func() {
+		wsc.pendingMu.Lock()
+		if wsc.pending[id] == req {
+			delete(wsc.pending, id)
+		}
+		wsc.pendingMu.Unlock()
+	}()
+
+	if err := wsc.enqueue(&wsMsg{t: websocket.TextMessage, msg: payload}); err != nil {
+		return nil, err
+	}
+	select {
+	case message := <-req.resp:
+		return message, nil
+	case <-done:
+		return nil, wsc.lastCloseErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// pendingRequest 等待响应的请求
+type pendingRequest struct {
+	// 收到的响应
+	resp chan []byte
+	// 取消等待，Request返回context.Canceled
+	cancel context.CancelFunc
+}
+
+// PendingRequests 返回正在等待响应的请求id，按id排序
+func (wsc *Wsc) PendingRequests() []string {
+	wsc.pendingMu.Lock()
+	defer wsc.pendingMu.Unlock()
+	ids := make([]string, 0, len(wsc.pending))
+	for id := range wsc.pending {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// CancelRequest 取消等待id对应的响应，等待中的Request返回context.Canceled，之后收到的响应按普通消息分发；
+// 没有等待中的请求时不做任何操作
+func (wsc *Wsc) CancelRequest(id string) {
+	wsc.pendingMu.Lock()
+	req, ok := wsc.pending[id]
+	if ok {
+		delete(wsc.pending, id)
+	}
+	wsc.pendingMu.Unlock()
+	if ok {
+		req.cancel()
+	}
+}
+
+// resolve 收到的消息是等待中请求的响应时交给等待方，返回是否已处理
+func (wsc *Wsc) resolve(message []byte) bool {
+	if wsc.cfg().IDExtractor == nil {
+		return false
+	}
+	id, ok := wsc.cfg().IDExtractor(message)
+	if !ok {
+		return false
+	}
+	wsc.pendingMu.Lock()
+	req, ok := wsc.pending[id]
+	if ok {
+		delete(wsc.pending, id)
+	}
+	wsc.pendingMu.Unlock()
+	if !ok {
+		return false
+	}
+	req.resp <- message
+	return true
+}
+
+// ReadMessage 同步等待下一条收到的消息，等待期间收到的第一条消息交给调用方而不再分发到接收消息回调，
+// 多个调用方同时等待时按调用顺序依次接收；ctx结束时返回ctx的错误，连接断开时返回断开原因
+func (wsc *Wsc) ReadMessage(ctx context.Context) (messageType int, data []byte, err error) {
+	wsc.WebSocket.connMu.RLock()
+	connected, done := wsc.WebSocket.isConnected, wsc.WebSocket.done
+	wsc.WebSocket.connMu.RUnlock()
+	if !connected {
+		return 0, nil, wsc.lastCloseErr()
+	}
+
+	ch := make(chan Message, 1)
+	wsc.readersMu.Lock()
+	wsc.readers = append(wsc.readers, ch)
+	wsc.readersMu.Unlock()
+	select {
+	case message := <-ch:
+		return message.Type, message.Data, nil
+	case <-done:
+		err = wsc.lastCloseErr()
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	// 取消等待前消息可能已经交给了本次调用
+	if !wsc.removeReader(ch) {
+		message := <-ch
+		return message.Type, message.Data, nil
+	}
+	return 0, nil, err
+}
+
+// removeReader 取消ReadMessage的等待，返回是否在消息到达前取消
+func (wsc *Wsc) removeReader(ch chan Message) bool {
+	wsc.readersMu.Lock()
+	defer wsc.readersMu.Unlock()
+	for i, reader := range wsc.readers {
+		if reader == ch {
+			wsc.readers = append(wsc.readers[:i], wsc.readers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// divert 有调用方在ReadMessage等待时将消息交给最早的调用方，返回是否已处理
+func (wsc *Wsc) divert(messageType int, message []byte) bool {
+	wsc.readersMu.Lock()
+	if len(wsc.readers) == 0 {
+		wsc.readersMu.Unlock()
+		return false
+	}
+	ch := wsc.readers[0]
+	wsc.readers = wsc.readers[1:]
+	wsc.readersMu.Unlock()
+	ch <- Message{Type: messageType, Data: message}
+	return true
+}
+
+// liveness 连接存活检测，读到消息以及收到ping、pong时刷新读超时和最近收到数据的时间，
+// gorilla在读取时同步执行ping、pong处理，所有调用都发生在读协程中（连接前的初始化除外）
+type liveness struct {
+	conn *websocket.Conn
+	// 读超时，0表示不设置读超时
+	timeout time.Duration
+	// 最近一次收到数据的时间，UnixNano，原子操作，跨连接共享供Healthy读取
+	last *int64
+}
+
+func newLiveness(conn *websocket.Conn, timeout time.Duration, last *int64) *liveness {
+	return &liveness{conn: conn, timeout: timeout, last: last}
+}
+
+// alive 记录at时收到数据，配置了读超时时从at起延长读超时，超时未收到任何数据将视为断线
+func (l *liveness) alive(at time.Time) {
+	atomic.StoreInt64(l.last, at.UnixNano())
+	if l.timeout > 0 {
+		_ = l.conn.SetReadDeadline(at.Add(l.timeout))
+	}
+}
+
+// readStream 流式读取一条消息交给回调处理，回调返回后丢弃未读完的数据
+func (wsc *Wsc) readStream(conn *websocket.Conn, onMessageStream func(messageType int, r io.Reader)) error {
+	messageType, r, err := conn.NextReader()
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&wsc.stats.MessagesReceived, 1)
+	wsc.safe(func() { onMessageStream(messageType, r) })
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+// writeLoop 消息发送
+func (wsc *Wsc) writeLoop(conn *websocket.Conn, bc *batchConn, sendChan, prioChan chan *wsMsg, done chan struct{}) {
+	// 关闭心跳或心跳间隔不为正数时不创建定时器，nil通道永远不会触发
+	var keepaliveTick <-chan time.Time
+	var ticker Ticker
+	if !wsc.cfg().DisableKeepalive && wsc.cfg().KeepaliveTime > 0 {
+		ticker = wsc.cfg().clock().NewTicker(wsc.cfg().KeepaliveTime)
+		keepaliveTick = ticker.C()
+	}
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	limiter := wsc.newLimiter()
+	// 连续发送的高优先级消息数量
+	burst := 0
+	for {
+		// 暂停时不再从缓冲通道取消息，直到恢复
+		in, prio, resumed := sendChan, prioChan, wsc.pausedChan()
+		if resumed != nil {
+			in, prio = nil, nil
+		}
+		// 优先发送高优先级消息，连续发送过多且有普通消息等待时让普通消息先发送一条，避免饿死
+		if burst >= maxPriorityBurst && len(in) > 0 {
+			prio = nil
+		} else if prio != nil {
+			select {
+			case msg := <-prio:
+				burst++
+				if !wsc.holdWhilePaused(done, keepaliveTick) || !wsc.waitRate(limiter, []*wsMsg{msg}, done, keepaliveTick) {
+					return
+				}
+				wsc.writeBatch(conn, nil, []*wsMsg{msg})
+				continue
+			default:
+			}
+		}
+		select {
+		case <-done:
+			return
+		case <-resumed:
+		case msg := <-prio:
+			burst++
+			if !wsc.holdWhilePaused(done, keepaliveTick) || !wsc.waitRate(limiter, []*wsMsg{msg}, done, keepaliveTick) {
+				return
+			}
+			wsc.writeBatch(conn, nil, []*wsMsg{msg})
+		case msg := <-in:
+			burst = 0
+			batch := []*wsMsg{msg}
+			if wsc.cfg().WriteBatchWindow > 0 {
+				max := cap(sendChan)
+				if limiter != nil && limiter.Burst() < max {
+					max = limiter.Burst()
+				}
+				batch = wsc.collectBatch(batch, max, sendChan, done)
+			}
+			if !wsc.holdWhilePaused(done, keepaliveTick) || !wsc.waitRate(limiter, batch, done, keepaliveTick) {
+				return
+			}
+			wsc.writeBatch(conn, bc, batch)
+		case <-keepaliveTick:
+			// 最近发送过消息时推迟心跳，到距最近一次发送满KeepaliveTime时再检查
+			if wait := wsc.idleKeepaliveDelay(); wait > 0 {
+				ticker.Reset(wait)
+				continue
+			}
+			wsc.keepalive()
+			if wsc.cfg().IdleKeepalive && wsc.cfg().KeepaliveTime > 0 {
+				ticker.Reset(wsc.cfg().KeepaliveTime)
+			}
+		case <-wsc.keepaliveReset:
+			switch {
+			case wsc.cfg().DisableKeepalive || wsc.cfg().KeepaliveTime <= 0:
+			case ticker == nil:
+				// 连接时心跳间隔不合法未创建定时器，修改为合法值后开始心跳
+				ticker = wsc.cfg().clock().NewTicker(wsc.cfg().KeepaliveTime)
+				keepaliveTick = ticker.C()
+			default:
+				ticker.Reset(wsc.cfg().KeepaliveTime)
+			}
+		}
+
+	}
+}
+
+// idleKeepaliveDelay 开启IdleKeepalive时返回还需等待多久才发送心跳，0表示立即发送
+func (wsc *Wsc) idleKeepaliveDelay() time.Duration {
+	if !wsc.cfg().IdleKeepalive {
+		return 0
+	}
+	last := atomic.LoadInt64(&wsc.lastSent)
+	if last == 0 {
+		return 0
+	}
+	wait := wsc.cfg().KeepaliveTime - wsc.cfg().clock().Now().Sub(time.Unix(0, last))
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// keepalive 发送心跳，配置了HeartbeatMessage时发送应用层心跳消息代替ping
+func (wsc *Wsc) keepalive() {
+	if len(wsc.cfg().HeartbeatMessage) > 0 {
+		_ = wsc.sendHeartbeat()
+	} else {
+		_ = wsc.SendPing(nil)
+	}
+	if f := wsc.cb().onKeepalive; f != nil {
+		wsc.safe(f)
+	}
+}
+
+// sendHeartbeat 发送应用层心跳消息，不经过缓冲通道和拦截器，也不触发发送回调
+func (wsc *Wsc) sendHeartbeat() error {
+	conn := wsc.currentConn()
+	if conn == nil {
+		return wsc.lastCloseErr()
+	}
+	messageType := wsc.cfg().HeartbeatType
+	if messageType == 0 {
+		messageType = websocket.TextMessage
+	}
+	wsc.WebSocket.sendMu.Lock()
+	defer wsc.WebSocket.sendMu.Unlock()
+	if err := conn.SetWriteDeadline(time.Now().Add(wsc.cfg().WriteWait)); err != nil {
+		return err
+	}
+	return conn.WriteMessage(messageType, wsc.cfg().HeartbeatMessage)
+}
+
+// newLimiter 根据配置创建发送速率限制，不限制时返回nil
+func (wsc *Wsc) newLimiter() *rate.Limiter {
+	if wsc.cfg().SendRateLimit <= 0 {
+		return nil
+	}
+	burst := wsc.cfg().SendRateBurst
+	if burst <= 0 {
+		burst = 1
+	}
+	return rate.NewLimiter(wsc.cfg().SendRateLimit, burst)
+}
+
+// holdWhilePaused 取出消息后处于暂停状态时等待恢复，写协程阻塞等待消息时调用的Pause只能在取出消息后发现，
+// 等待期间照常发送心跳，连接断开时返回false
+func (wsc *Wsc) holdWhilePaused(done chan struct{}, keepalive <-chan time.Time) bool {
+	for {
+		resumed := wsc.pausedChan()
+		if resumed == nil {
+			return true
+		}
+		select {
+		case <-resumed:
+		case <-done:
+			return false
+		case <-keepalive:
+			wsc.keepalive()
+		}
+	}
+}
+
+// waitRate 等待发送速率限制允许发送这批消息，等待期间照常发送心跳，连接断开时返回false
+func (wsc *Wsc) waitRate(limiter *rate.Limiter, batch []*wsMsg, done chan struct{}, keepalive <-chan time.Time) bool {
+	if limiter == nil {
+		return true
+	}
+	// 标记消息不占用配额
+	n := 0
+	for _, msg := range batch {
+		if msg.flushed == nil {
+			n++
+		}
+	}
+	if n == 0 {
+		return true
+	}
+	delay := limiter.ReserveN(time.Now(), n).Delay()
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-done:
+			return false
+		case <-keepalive:
+			wsc.keepalive()
+		}
+	}
+}
+
+// maxPriorityBurst 有普通消息等待时最多连续发送的高优先级消息数量
+const maxPriorityBurst = 8
+
+// collectBatch 收集批量发送窗口内到达的消息，最多收集max条
+func (wsc *Wsc) collectBatch(batch []*wsMsg, max int, sendChan chan *wsMsg, done chan struct{}) []*wsMsg {
+	timer := time.NewTimer(wsc.cfg().WriteBatchWindow)
+	defer timer.Stop()
+	for len(batch) < max {
+		select {
+		case msg := <-sendChan:
+			batch = append(batch, msg)
+		case <-timer.C:
+			return batch
+		case <-done:
+			return batch
+		}
+	}
+	return batch
+}
+
+// writeBatch 按顺序发送一批消息，整批只加一次发送锁，发送完成后再依次回调，
+// bc不为nil且有多条消息时整批写入缓冲区后一次写入连接，写入连接失败时缓冲中的消息都按发送失败回调；
+// 写入时发生连接级错误说明连接已不可用，由写协程主动断开并重连，无需等待读协程发现
+func (wsc *Wsc) writeBatch(conn *websocket.Conn, bc *batchConn, batch []*wsMsg) {
+	var fatalErr error
+	errs := make([]error, len(batch))
+	buffered := bc != nil && len(batch) > 1
+	wsc.WebSocket.sendMu.Lock()
+	if buffered {
+		bc.begin()
+	}
+	for i, wsMsg := range batch {
+		switch {
+		case wsMsg.flushed != nil:
+		// 丢弃在缓冲通道中等待过久的消息
+		case wsMsg.expired():
+			errs[i] = errExpired
+		default:
+			errs[i] = wsc.send(conn, wsMsg)
+		}
+	}
+	if buffered {
+		if err := bc.flush(); err != nil {
+			for i, wsMsg := range batch {
+				if wsMsg.flushed == nil && errs[i] == nil {
+					errs[i] = err
+				}
+			}
+		}
+	}
+	wsc.WebSocket.sendMu.Unlock()
+	for i, wsMsg := range batch {
+		if wsMsg.flushed != nil || errs[i] == errExpired {
+			continue
+		}
+		if errs[i] == nil {
+			atomic.StoreInt64(&wsc.lastSent, wsc.cfg().clock().Now().UnixNano())
+			atomic.AddUint64(&wsc.stats.MessagesSent, 1)
+			atomic.AddUint64(&wsc.stats.BytesSent, uint64(len(wsMsg.msg)))
+		}
+		if fatalErr == nil && isFatalWriteErr(errs[i]) {
+			fatalErr = errs[i]
+		}
+	}
+
+	// 每个回调单独捕获panic，回调panic时继续处理后续的回调
+	for i, wsMsg := range batch {
+		msg, err := wsMsg.msg, errs[i]
+		switch {
+		case wsMsg.flushed != nil:
+			close(wsMsg.flushed)
+		case err == errExpired:
+			if f := wsc.cb().onMessageExpired; f != nil {
+				wsc.safe(func() { f(msg) })
+			}
+		case err != nil:
+			if f := wsc.cb().onSentError; f != nil {
+				wsc.safe(func() { f(err) })
+			}
+			if f := wsc.cb().onWriteTimeout; f != nil && isTimeoutErr(err) {
+				wsc.safe(func() { f(msg) })
+			}
+		case wsMsg.t == websocket.TextMessage:
+			if f := wsc.cb().onTextMessageSent; f != nil {
+				wsc.safe(func() { f(msg) })
+			}
+		case wsMsg.t == websocket.BinaryMessage:
+			if f := wsc.cb().onBinaryMessageSent; f != nil {
+				wsc.safe(func() { f(msg) })
+			}
+		}
+	}
+	if fatalErr != nil {
+		wsc.closeAndRecConn(conn, fatalErr)
+	}
+}
+
+// batchBufferSize 批量发送时缓冲区的大小，整批超过该大小时写满即写入连接
+const batchBufferSize = 64 << 10
+
+// batchConn 批量发送时包装拨号得到的底层连接，begin和flush之间的写入先进入缓冲区，flush时一次写入连接，
+// 期间并发写入的控制帧同样进入缓冲区，与数据帧保持顺序；
+// wss连接包装在TLS之下，整批的TLS记录同样合并写入
+type batchConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf *bufio.Writer
+	// 是否处于批量写入中
+	batching bool
+}
+
+func (c *batchConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.batching {
+		return c.buf.Write(p)
+	}
+	return c.Conn.Write(p)
+}
+
+// Close 关闭前写出批量写入中已缓冲的数据，避免并发关闭时丢失缓冲中的关闭帧
+func (c *batchConn) Close() error {
+	c.mu.Lock()
+	if c.batching {
+		_ = c.buf.Flush()
+		c.batching = false
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// begin 开始批量写入
+func (c *batchConn) begin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.buf == nil {
+		c.buf = bufio.NewWriterSize(c.Conn, batchBufferSize)
+	}
+	c.batching = true
+}
+
+// flush 结束批量写入，将缓冲区写入连接；写入失败时丢弃缓冲区，之后的写入直接返回底层连接的错误
+func (c *batchConn) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batching = false
+	err := c.buf.Flush()
+	if err != nil {
+		c.buf.Reset(c.Conn)
+	}
+	return err
+}
+
+// batchDialer 开启批量发送时复制d，用batchConn包装拨号得到的连接并保存到bc，未开启时原样返回d
+func (wsc *Wsc) batchDialer(d *websocket.Dialer, bc **batchConn) *websocket.Dialer {
+	if wsc.cfg().WriteBatchWindow <= 0 {
+		return d
+	}
+	dialer := *d
+	wrap := func(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			*bc = &batchConn{Conn: conn}
+			return *bc, nil
+		}
+	}
+	switch {
+	case d.NetDialContext != nil:
+		dialer.NetDialContext = wrap(d.NetDialContext)
+	case d.NetDial != nil:
+		netDial := d.NetDial
+		dialer.NetDialContext = wrap(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return netDial(network, addr)
+		})
+	default:
+		netDialer := &net.Dialer{}
+		dialer.NetDialContext = wrap(netDialer.DialContext)
+	}
+	if d.NetDialTLSContext != nil {
+		dialer.NetDialTLSContext = wrap(d.NetDialTLSContext)
+	}
+	return &dialer
+}
+
+// isFatalWriteErr 写错误是否说明连接已不可用，gorilla在底层写失败后会记住错误，之后的写入都会失败
+func isFatalWriteErr(err error) bool {
+	if err == nil || errors.Is(err, ErrClose) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, ErrReaderAborted) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, websocket.ErrCloseSent)
+}
+
+// isTimeoutErr 是否为写超时，gorilla会包装底层错误，只保留Timeout信息
+func isTimeoutErr(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// SendTextMessage 发送TextMessage消息，消息放入缓冲通道后立即返回，不等待写入连接，
+// 可在接收消息等回调中安全调用；缓冲通道已满时按OverflowPolicy处理
+func (wsc *Wsc) SendTextMessage(message string) error {
+	return wsc.enqueue(&wsMsg{
+		t:   websocket.TextMessage,
+		msg: []byte(message),
+	})
+}
+
+// SendTextMessages 依次发送多条TextMessage消息，返回与messages按下标对应的错误，发送成功的为nil，
+// 连接已关闭时剩余的消息不再尝试，直接返回同样的错误
+func (wsc *Wsc) SendTextMessages(messages []string) []error {
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		errs[i] = wsc.SendTextMessage(message)
+		if errors.Is(errs[i], ErrClose) {
+			for j := i + 1; j < len(messages); j++ {
+				errs[j] = errs[i]
+			}
+			break
+		}
+	}
+	return errs
+}
+
+// SendTextMessagePriority 按优先级发送TextMessage消息，高优先级消息会越过缓冲通道中等待的普通消息优先发送，
+// 不同优先级的消息之间不保证顺序；开启StrictOrdering或PreserveMixedOrder时按普通消息发送
+func (wsc *Wsc) SendTextMessagePriority(message string, priority Priority) error {
+	return wsc.enqueue(&wsMsg{
+		t:        websocket.TextMessage,
+		msg:      []byte(message),
+		priority: priority,
+	})
+}
+
+// SendTextMessageTTL 发送TextMessage消息，消息在缓冲通道中等待超过ttl后将被丢弃而不再发送
+func (wsc *Wsc) SendTextMessageTTL(message string, ttl time.Duration) error {
+	return wsc.enqueue(&wsMsg{
+		t:        websocket.TextMessage,
+		msg:      []byte(message),
+		expireAt: time.Now().Add(ttl),
+	})
+}
+
+// SendTextMessageWithDeadline 发送TextMessage消息，使用d作为本条消息的写超时，0表示使用Config.WriteWait
+func (wsc *Wsc) SendTextMessageWithDeadline(message string, d time.Duration) error {
+	return wsc.enqueue(&wsMsg{
+		t:         websocket.TextMessage,
+		msg:       []byte(message),
+		writeWait: d,
+	})
+}
+
+// SendTextMessage 发送TextMessage消息
+func (wsc *Wsc) SendByteMessage(message []byte) error {
+	return wsc.enqueue(&wsMsg{
+		t:   websocket.TextMessage,
+		msg: message,
+	})
+}
+
+// SendBinaryMessage 发送BinaryMessage消息
+func (wsc *Wsc) SendBinaryMessage(data []byte) error {
+	return wsc.enqueue(&wsMsg{
+		t:   websocket.BinaryMessage,
+		msg: data,
+	})
+}
+
+// SendBinaryMessageWithDeadline 发送BinaryMessage消息，使用d作为本条消息的写超时，0表示使用Config.WriteWait
+func (wsc *Wsc) SendBinaryMessageWithDeadline(data []byte, d time.Duration) error {
+	return wsc.enqueue(&wsMsg{
+		t:         websocket.BinaryMessage,
+		msg:       data,
+		writeWait: d,
+	})
+}
+
+// SendMessageCompression 发送messageType类型的消息，compress为false时本条消息不压缩，
+// 适用于图片等已压缩的数据以节省CPU；仅在Dialer启用EnableCompression且服务端支持时生效
+func (wsc *Wsc) SendMessageCompression(messageType int, data []byte, compress bool) error {
+	return wsc.enqueue(&wsMsg{
+		t:             messageType,
+		msg:           data,
+		noCompression: !compress,
+	})
+}
+
+// SendPrepared 发送预先编码的消息，同一内容广播给大量客户端时只需编码和压缩一次，
+// 不经过出站拦截器，也不触发发送成功回调
+func (wsc *Wsc) SendPrepared(pm *websocket.PreparedMessage) error {
+	return wsc.enqueue(&wsMsg{prepared: pm})
+}
+
+// SendTextReader 流式发送TextMessage消息，写协程从r中读取数据直接写入连接，适用于大消息，
+// WriteWait为整条消息的写超时，发送成功回调的消息内容为nil；读取r失败时断开并重连，OnSentError收到ErrReaderAborted
+func (wsc *Wsc) SendTextReader(r io.Reader) error {
+	return wsc.enqueue(&wsMsg{
+		t:      websocket.TextMessage,
+		reader: r,
+	})
+}
+
+// SendBinaryReader 流式发送BinaryMessage消息，写协程从r中读取数据直接写入连接，适用于大消息，
+// WriteWait为整条消息的写超时，发送成功回调的消息内容为nil；读取r失败时断开并重连，OnSentError收到ErrReaderAborted
+func (wsc *Wsc) SendBinaryReader(r io.Reader) error {
+	return wsc.enqueue(&wsMsg{
+		t:      websocket.BinaryMessage,
+		reader: r,
+	})
+}
+
+// SendBinaryStream 分块流式发送BinaryMessage消息，适用于文件传输，写协程每次从r中读取chunkSize字节写入连接，
+// 每块写入前按WriteWait重新计算写超时，写入后触发OnSendProgress；chunkSize小于等于0时使用DefaultChunkSize，
+// 连接断开时停止读取r并放弃本条消息；读取r失败时断开并重连，OnSentError收到ErrReaderAborted
+func (wsc *Wsc) SendBinaryStream(r io.Reader, chunkSize int) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return wsc.enqueue(&wsMsg{
+		t:         websocket.BinaryMessage,
+		reader:    r,
+		chunkSize: chunkSize,
+	})
+}
+
+// enqueue 将消息丢入缓冲通道处理，通道已满时按OverflowPolicy处理
+func (wsc *Wsc) enqueue(msg *wsMsg) error {
+	wsc.prepare(msg)
+	if wsc.cfg().OverflowPolicy == Block {
+		return wsc.enqueueBlocking(context.Background(), msg)
+	}
+	if wsc.cfg().strictOrdering() {
+		wsc.WebSocket.enqueueMu.Lock()
+		defer wsc.WebSocket.enqueueMu.Unlock()
+	}
+	wsc.WebSocket.connMu.RLock()
+	connected, sendChan := wsc.WebSocket.isConnected && !wsc.WebSocket.closing, wsc.WebSocket.queue(msg)
+	wsc.WebSocket.connMu.RUnlock()
+	if !connected {
+		return wsc.lastCloseErr()
+	}
+	for {
+		select {
+		case sendChan <- msg:
+			return nil
+		default:
+		}
+		if f := wsc.cb().onBufferFull; f != nil {
+			wsc.safe(f)
+		}
+		if wsc.cfg().OverflowPolicy != DropOldest {
+			return ErrBuffer
+		}
+		// 丢弃最早的消息后重试，写协程可能同时取走消息，此时通道已有空位
+		select {
+		case dropped := <-sendChan:
+			// 标记消息被丢弃时直接通知等待方，避免Shutdown一直等待
+			if dropped.flushed != nil {
+				close(dropped.flushed)
+			}
+		default:
+		}
+	}
+}
+
+// prepare 入队前处理消息，严格保序时忽略优先级，开启SequenceTags时分配序列号，已分配的消息不再重复分配
+func (wsc *Wsc) prepare(msg *wsMsg) {
+	if wsc.cfg().strictOrdering() {
+		msg.priority = PriorityNormal
+	}
+	if !wsc.cfg().SequenceTags || msg.seq != 0 || msg.reader != nil || msg.prepared != nil || msg.flushed != nil {
+		return
+	}
+	msg.seq = atomic.AddUint64(&wsc.seq, 1)
+}
+
+// queue 返回消息对应优先级的缓冲通道，调用方需持有connMu
+func (ws *WebSocket) queue(msg *wsMsg) chan *wsMsg {
+	if msg.priority == PriorityHigh {
+		return ws.prioChan
+	}
+	return ws.sendChan
+}
+
+// SendBinaryMessageBlocking 发送BinaryMessage消息，缓冲通道已满时阻塞等待，直到有空位、ctx结束或连接断开
+func (wsc *Wsc) SendBinaryMessageBlocking(ctx context.Context, data []byte) error {
+	return wsc.enqueueBlocking(ctx, &wsMsg{
+		t:   websocket.BinaryMessage,
+		msg: data,
+	})
+}
+
+// enqueueBlocking 将消息丢入缓冲通道，通道已满时阻塞等待
+func (wsc *Wsc) enqueueBlocking(ctx context.Context, msg *wsMsg) error {
+	wsc.prepare(msg)
+	if wsc.cfg().strictOrdering() {
+		wsc.WebSocket.enqueueMu.Lock()
+		defer wsc.WebSocket.enqueueMu.Unlock()
+	}
+	wsc.WebSocket.connMu.RLock()
+	connected := wsc.WebSocket.isConnected && !wsc.WebSocket.closing
+	sendChan, done := wsc.WebSocket.queue(msg), wsc.WebSocket.done
+	wsc.WebSocket.connMu.RUnlock()
+	if !connected {
+		return wsc.lastCloseErr()
+	}
+	select {
+	case sendChan <- msg:
+		return nil
+	default:
+	}
+	// 缓冲通道已满，开始等待前通知一次
+	if f := wsc.cb().onBufferFull; f != nil {
+		wsc.safe(f)
+	}
+	select {
+	case sendChan <- msg:
+		return nil
+	case <-done:
+		return wsc.lastCloseErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// BufferLen 返回普通优先级缓冲通道中等待发送的消息数量，不包括高优先级消息，后者见PriorityBufferLen
+func (wsc *Wsc) BufferLen() int {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	return len(wsc.WebSocket.sendChan)
+}
+
+// PriorityBufferLen 返回高优先级缓冲通道中等待发送的消息数量
+func (wsc *Wsc) PriorityBufferLen() int {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	return len(wsc.WebSocket.prioChan)
+}
+
+// BufferCap 返回缓冲通道的容量，普通优先级和高优先级缓冲通道各有该容量
+func (wsc *Wsc) BufferCap() int {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	return cap(wsc.WebSocket.sendChan)
+}
+
+// SendPing 发送Ping控制帧
+func (wsc *Wsc) SendPing(appData []byte) error {
+	return wsc.sendControl(websocket.PingMessage, appData)
+}
+
+// SendPong 发送Pong控制帧，可在OnPingReceived中自定义回复服务端的Ping
+func (wsc *Wsc) SendPong(appData []byte) error {
+	return wsc.sendControl(websocket.PongMessage, appData)
+}
+
+// SendClose 发送Close控制帧，不清理连接，等待服务端回复Close后由关闭回调清理
+func (wsc *Wsc) SendClose(code int, text string) error {
+	return wsc.sendControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+}
+
+// sendControl 发送控制帧，控制帧不经过缓冲通道，可与其他写操作并发
+func (wsc *Wsc) sendControl(messageType int, data []byte) error {
+	conn := wsc.currentConn()
+	if conn == nil {
+		return wsc.lastCloseErr()
+	}
+	// 超时时间
+	deadline := time.Now().Add(wsc.cfg().WriteWait)
+	return conn.WriteControl(messageType, data, deadline)
+}
+
+// currentConn 返回当前连接，未连接时返回nil，连接在重连时会被替换，使用方不能直接读取WebSocket.Conn
+func (wsc *Wsc) currentConn() *websocket.Conn {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	if !wsc.WebSocket.isConnected {
+		return nil
+	}
+	return wsc.WebSocket.Conn
+}
+
+// send 发送消息到连接端，调用方需持有sendMu
+func (wsc *Wsc) send(conn *websocket.Conn, msg *wsMsg) error {
+	// 连接已断开或已被替换
+	if wsc.currentConn() != conn {
+		return wsc.lastCloseErr()
+	}
+	// 超时时间，消息未指定时使用全局配置
+	writeWait := msg.writeWait
+	if writeWait <= 0 {
+		writeWait = wsc.cfg().WriteWait
+	}
+	deadline := time.Now().Add(writeWait)
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	// 只有写协程写入消息，临时关闭压缩不影响其他消息
+	if msg.noCompression {
+		conn.EnableWriteCompression(false)
+		defer conn.EnableWriteCompression(true)
+	}
+	if msg.reader != nil && msg.chunkSize > 0 {
+		return wsc.sendChunks(conn, msg, writeWait)
+	}
+	if msg.reader != nil {
+		return sendReader(conn, msg.t, msg.reader)
+	}
+	if msg.prepared != nil {
+		return conn.WritePreparedMessage(msg.prepared)
+	}
+	data, err := wsc.intercept(wsc.cb().outbound, msg.msg)
+	if err != nil {
+		return err
+	}
+	if msg.seq != 0 {
+		data = TagSequence(msg.seq, data)
+	}
+	if !msg.noCompression && !wsc.cfg().compress(len(data)) {
+		conn.EnableWriteCompression(false)
+		defer conn.EnableWriteCompression(true)
+	}
+	return conn.WriteMessage(msg.t, data)
+}
+
+// intercept 依次执行拦截器，开启RecoverCallbacks时拦截器panic返回ErrInterceptorPanic，
+// 出站拦截器在持有sendMu时执行，panic不能传播出去
+func (wsc *Wsc) intercept(interceptors []func(data []byte) ([]byte, error), data []byte) (out []byte, err error) {
+	if len(interceptors) == 0 {
+		return data, nil
+	}
+	if wsc.cfg().RecoverCallbacks {
+		defer func() {
+			if r := recover(); r != nil {
+				wsc.reportPanic(r)
+				out, err = nil, fmt.Errorf("%w: %v", ErrInterceptorPanic, r)
+			}
+		}()
+	}
+	for _, f := range interceptors {
+		var err error
+		if data, err = f(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// sendReader 将reader中的数据以单条消息流式写入连接，不完整缓存整个消息，读取失败时中止消息并断开连接
+func sendReader(conn *websocket.Conn, messageType int, r io.Reader) error {
+	w, err := conn.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	src := &sourceReader{r: r}
+	if _, err := io.Copy(w, src); err != nil {
+		if src.err != nil {
+			return abortMessage(conn, src.err)
+		}
+		return err
+	}
+	return w.Close()
+}
+
+// sourceReader 记录读取消息数据时的错误，用于区分读取失败和写入连接失败
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// abortMessage 读取消息数据失败时直接关闭底层连接，不关闭消息的writer，对端不会收到结束帧，
+// 已写出的部分不会被当作完整的消息
+func abortMessage(conn *websocket.Conn, err error) error {
+	_ = conn.UnderlyingConn().Close()
+	return fmt.Errorf("%w: %v", ErrReaderAborted, err)
+}
+
+// sendChunks 将reader中的数据按chunkSize分块写入同一条消息，每块写入前按writeWait延长写超时，
+// 写入后回调累计发送的字节数；连接断开或被替换时停止写入并返回断开原因，读取失败时中止消息并断开连接
+func (wsc *Wsc) sendChunks(conn *websocket.Conn, msg *wsMsg, writeWait time.Duration) error {
+	w, err := conn.NextWriter(msg.t)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, msg.chunkSize)
+	var sent int64
+	for {
+		n, readErr := io.ReadFull(msg.reader, buf)
+		if n > 0 {
+			if wsc.currentConn() != conn {
+				return wsc.lastCloseErr()
+			}
+			if err := conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+				return err
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			sent += int64(n)
+			if f := wsc.cb().onSendProgress; f != nil {
+				wsc.safe(func() { f(sent) })
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return w.Close()
+		}
+		if readErr != nil {
+			return abortMessage(conn, readErr)
+		}
+	}
+}
+
+// closeAndRecConn 断线重连，连接已被主动关闭或替换时由关闭方负责回调
+func (wsc *Wsc) closeAndRecConn(conn *websocket.Conn, err error) {
+	reason := &ClosedError{Code: websocket.CloseAbnormalClosure, Err: err}
+	var closeErr *websocket.CloseError
+	isCloseErr := errors.As(err, &closeErr)
+	if isCloseErr {
+		reason.Code, reason.Text = closeErr.Code, closeErr.Text
+	}
+	// 手动处理服务端关闭时保留连接，只回调关闭码
+	if isCloseErr && closeErr.Code != websocket.CloseAbnormalClosure && wsc.cfg().ManualCloseHandling {
+		wsc.WebSocket.connMu.RLock()
+		current := wsc.WebSocket.isConnected && wsc.WebSocket.Conn == conn
+		wsc.WebSocket.connMu.RUnlock()
+		if current {
+			wsc.closed(closeErr.Code, closeErr.Text, false)
+		}
 		return
 	}
+	if !wsc.cleanConn(conn, reason) {
+		return
+	}
+	wsc.WebSocket.connMu.RLock()
+	connectedAt, life := wsc.WebSocket.connectedAt, wsc.WebSocket.life
+	wsc.WebSocket.connMu.RUnlock()
+	tooBig := errors.Is(err, websocket.ErrReadLimit)
+	// 服务端主动发送关闭帧时不重连，消息超长时重连后大概率再次收到同样的消息，默认不重连
+	reconnect := wsc.cfg().EnableReconnect &&
+		!(isCloseErr && closeErr.Code != websocket.CloseAbnormalClosure) &&
+		!(tooBig && !wsc.cfg().ReconnectOnMessageTooBig)
+	if reconnect {
+		wsc.setState(Reconnecting)
+	} else {
+		wsc.setState(Disconnected)
+	}
+	// 服务端发送关闭帧或连接异常关闭时，回调关闭码
+	cb := wsc.cb()
+	if tooBig && cb.onMessageTooBig != nil {
+		wsc.safe(func() { cb.onMessageTooBig(wsc.readLimit()) })
+	}
+	if isCloseErr {
+		wsc.closed(closeErr.Code, closeErr.Text, false)
+	}
+	if cb.onDisconnected != nil {
+		wsc.safe(func() { cb.onDisconnected(err) })
+	}
+	if reconnect {
+		wsc.goConnect(life, wsc.reconnectDelay(connectedAt))
+	}
+}
+
+// SetURL 更换连接地址，已连接时断开并重连到新地址，正在重连时下一次尝试使用新地址，未连接时只更换地址
+func (wsc *Wsc) SetURL(url string) {
+	wsc.WebSocket.connMu.Lock()
+	wsc.WebSocket.Url = url
+	connected := wsc.WebSocket.isConnected
+	wsc.WebSocket.connMu.Unlock()
+	if connected {
+		wsc.Reconnect()
+	}
+}
+
+// urls 返回依次尝试的连接地址，主地址在前
+func (wsc *Wsc) urls() []string {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	return append([]string{wsc.WebSocket.Url}, wsc.cfg().FallbackURLs...)
+}
+
+// Reconnect 立即断开当前连接并重新连接，重连间隔从MinRecTime重新开始，未连接时直接发起连接，
+// 正在等待重连间隔时立即进行下一次尝试
+func (wsc *Wsc) Reconnect() {
+	wsc.backoff().Reset()
+	wsc.WebSocket.connMu.RLock()
+	dialing := wsc.WebSocket.dialing
+	wsc.WebSocket.connMu.RUnlock()
+	waiting := atomic.LoadInt64(&wsc.nextReconnectDelay) > 0
+	// 已有正在进行或等待中的重连时唤醒等待立即尝试，正在拨号时本次尝试失败后立即重试，无需再发起连接
+	if dialing || waiting {
+		select {
+		case wsc.reconnectKick <- struct{}{}:
+		default:
+		}
+		return
+	}
+	wsc.clean(&ClosedError{Code: websocket.CloseNormalClosure, Text: "reconnect"})
+	wsc.setState(Reconnecting)
+	wsc.goConnect(wsc.lifecycle(), 0)
+}
+
+// goConnect 在协程中等待delay后发起连接，生命周期life结束时不再连接
+func (wsc *Wsc) goConnect(life context.Context, delay time.Duration) {
+	wsc.mustSubmit(func() {
+		if delay > 0 {
+			atomic.StoreInt64(&wsc.nextReconnectDelay, int64(delay))
+			select {
+			case <-wsc.cfg().clock().After(delay):
+			case <-wsc.reconnectKick:
+			case <-life.Done():
+			}
+			atomic.StoreInt64(&wsc.nextReconnectDelay, 0)
+		}
+		if life.Err() != nil {
+			wsc.setState(Disconnected)
+			return
+		}
+		_ = wsc.ConnectContext(life)
+	})
+}
+
+// lifecycle 返回当前的生命周期，尚未创建或已结束时创建新的生命周期
+func (wsc *Wsc) lifecycle() context.Context {
+	wsc.lifeMu.Lock()
+	defer wsc.lifeMu.Unlock()
+	if wsc.life == nil {
+		wsc.life, wsc.lifeCancel = context.WithCancel(context.Background())
+	}
+	return wsc.life
+}
+
+// endLifecycle 结束当前的生命周期，正在重试和等待中的重连协程随之退出
+func (wsc *Wsc) endLifecycle() {
+	wsc.lifeMu.Lock()
+	defer wsc.lifeMu.Unlock()
+	if wsc.lifeCancel != nil {
+		wsc.lifeCancel()
+	}
+	wsc.life, wsc.lifeCancel = nil, nil
+}
+
+// Start 启动客户端，阻塞直到连接成功，连接失败时按退避策略重试，ctx结束或调用Stop时停止并返回错误；
+// 启动后断线按配置自动重连，直到调用Stop
+func (wsc *Wsc) Start(ctx context.Context) error {
+	return wsc.ConnectContext(ctx)
+}
+
+// Stop 停止客户端，不再重连，发送关闭帧并等待服务端回复，之后等待读写、重连和回调协程全部退出，
+// ctx结束时强制断开连接并返回ctx的错误
+func (wsc *Wsc) Stop(ctx context.Context) error {
+	if _, waitErr := wsc.closeWith(ctx, websocket.CloseNormalClosure, ""); waitErr != nil {
+		return waitErr
+	}
+	exited := make(chan struct{})
+	go func() {
+		wsc.tasks.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff 返回连接使用的退避策略，配置了ReconnectResetInterval时跨重连复用同一个退避，否则每次连接重新开始
+func (wsc *Wsc) backoff() *backoff.Backoff {
+	newBackoff := func() *backoff.Backoff {
+		return &backoff.Backoff{
+			Min:    wsc.cfg().MinRecTime,
+			Max:    wsc.cfg().MaxRecTime,
+			Factor: wsc.cfg().RecFactor,
+			Jitter: wsc.cfg().ReconnectJitter,
+		}
+	}
+	if wsc.cfg().ReconnectResetInterval <= 0 {
+		return newBackoff()
+	}
+	wsc.recMu.Lock()
+	defer wsc.recMu.Unlock()
+	if wsc.recBackoff == nil {
+		wsc.recBackoff = newBackoff()
+	}
+	return wsc.recBackoff
+}
+
+// reconnectDelay 断线后重连前的等待时间，连接持续健康超过ReconnectResetInterval时重置退避并立即重连，
+// 否则视为闪断，按累计的退避间隔等待
+func (wsc *Wsc) reconnectDelay(connectedAt time.Time) time.Duration {
+	if wsc.cfg().ReconnectResetInterval <= 0 {
+		return 0
+	}
+	b := wsc.backoff()
+	if time.Since(connectedAt) >= wsc.cfg().ReconnectResetInterval {
+		b.Reset()
+		return 0
+	}
+	return b.Duration()
+}
+
+// submit 提交任务到协程池，未配置协程池时直接开启协程
+func (wsc *Wsc) submit(task func()) error {
+	wsc.tasks.Add(1)
+	run := func() {
+		defer wsc.tasks.Done()
+		task()
+	}
+	if wsc.cfg().Pool == nil {
+		go run()
+		return nil
+	}
+	if err := wsc.cfg().Pool.Submit(run); err != nil {
+		wsc.tasks.Done()
+		return err
+	}
+	return nil
+}
+
+// mustSubmit 提交读写协程、重连等必须执行的任务，协程池提交失败时回调连接异常并直接开启协程，
+// 避免连接已建立却没有读写协程
+func (wsc *Wsc) mustSubmit(task func()) {
+	if err := wsc.submit(task); err != nil {
+		wsc.reportConnectError(err)
+		wsc.tasks.Add(1)
+		go func() {
+			defer wsc.tasks.Done()
+			task()
+		}()
+	}
+}
+
+// Close 主动关闭连接，正在连接或等待重连时停止重试
+func (wsc *Wsc) Close() {
+	wsc.CloseWithMsg("")
+}
+
+// CloseWithMsg 主动关闭连接，附带消息
+func (wsc *Wsc) CloseWithMsg(msg string) {
+	_ = wsc.CloseWithCode(websocket.CloseNormalClosure, msg)
+}
+
+// CloseWithCode 使用指定关闭码主动关闭连接，关闭码需为1000-1003、1007-1014或3000-4999，
+// 关闭码不合法时返回ErrInvalidCloseCode且不关闭连接，未连接时返回最后一次断开的原因
+func (wsc *Wsc) CloseWithCode(code int, text string) error {
+	if !validCloseCode(code) {
+		return fmt.Errorf("%w: %d", ErrInvalidCloseCode, code)
+	}
+	var ctx context.Context
+	if wsc.cfg().CloseGracePeriod > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), wsc.cfg().CloseGracePeriod)
+		defer cancel()
+	}
+	err, _ := wsc.closeWith(ctx, code, text)
+	return err
+}
+
+// CloseGraceful 发送关闭帧并等待服务端回复关闭帧，ctx结束前未收到回复时强制断开底层连接并返回ctx的错误，
+// 保证关闭不会无限等待；未连接时返回最后一次断开的原因
+func (wsc *Wsc) CloseGraceful(ctx context.Context) error {
+	sendErr, waitErr := wsc.closeWith(ctx, websocket.CloseNormalClosure, "")
+	if sendErr != nil {
+		return sendErr
+	}
+	return waitErr
+}
+
+// closeWith 发送关闭帧后关闭连接，ctx不为nil时先等待服务端回复关闭帧直到ctx结束，
+// 返回发送关闭帧的错误和等待回复的错误
+func (wsc *Wsc) closeWith(ctx context.Context, code int, text string) (sendErr, waitErr error) {
+	// 未连接时也要结束生命周期，正在重试的连接过程随之退出
+	wsc.endLifecycle()
+	if !wsc.IsConnected() {
+		return wsc.lastCloseErr(), nil
+	}
+	wsc.setState(Closing)
+	var acked chan struct{}
+	if ctx != nil {
+		wsc.WebSocket.connMu.Lock()
+		if wsc.WebSocket.closeAcked == nil {
+			wsc.WebSocket.closeAcked = make(chan struct{})
+		}
+		acked = wsc.WebSocket.closeAcked
+		wsc.WebSocket.connMu.Unlock()
+	}
+	sendErr = wsc.SendClose(code, text)
+	// 等待服务端回复关闭帧，完成关闭握手
+	if acked != nil && sendErr == nil {
+		select {
+		case <-acked:
+		case <-ctx.Done():
+			waitErr = ctx.Err()
+		}
+	}
+	// 连接已被其他协程清理时由其负责回调
+	cleaned := wsc.clean(&ClosedError{Code: code, Text: text})
+	wsc.setState(Closed)
+	if cleaned {
+		wsc.closed(code, text, true)
+	}
+	return sendErr, waitErr
+}
+
+// validCloseCode 关闭码是否允许在关闭帧中发送，1005、1006、1015等保留码只能用于本地表示
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003,
+		code >= 1007 && code <= 1014,
+		code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// Pause 暂停发送，消息继续进入缓冲通道但不会写入连接，连接和心跳保持不变
+func (wsc *Wsc) Pause() {
+	wsc.WebSocket.connMu.Lock()
+	defer wsc.WebSocket.connMu.Unlock()
+	if wsc.WebSocket.paused == nil {
+		wsc.WebSocket.paused = make(chan struct{})
+	}
+}
+
+// Resume 恢复发送，继续发送暂停期间缓冲的消息
+func (wsc *Wsc) Resume() {
 	wsc.WebSocket.connMu.Lock()
 	defer wsc.WebSocket.connMu.Unlock()
+	if wsc.WebSocket.paused != nil {
+		close(wsc.WebSocket.paused)
+		wsc.WebSocket.paused = nil
+	}
+}
+
+// pausedChan 返回暂停信号，未暂停时返回nil
+func (wsc *Wsc) pausedChan() chan struct{} {
+	wsc.WebSocket.connMu.RLock()
+	defer wsc.WebSocket.connMu.RUnlock()
+	return wsc.WebSocket.paused
+}
+
+// Shutdown 优雅关闭连接，不再接受新消息，将缓冲通道中的消息发送完成后再发送关闭帧，整个过程受ctx控制，
+// ctx结束时直接关闭连接并返回ctx的错误
+func (wsc *Wsc) Shutdown(ctx context.Context) error {
+	wsc.WebSocket.connMu.Lock()
+	if !wsc.WebSocket.isConnected {
+		wsc.WebSocket.connMu.Unlock()
+		return wsc.lastCloseErr()
+	}
+	wsc.WebSocket.closing = true
+	sendChan, prioChan, done := wsc.WebSocket.sendChan, wsc.WebSocket.prioChan, wsc.WebSocket.done
+	wsc.WebSocket.connMu.Unlock()
+	wsc.setState(Closing)
+
+	err := wsc.flush(ctx, done, prioChan, sendChan)
+	wsc.Close()
+	return err
+}
+
+// Flush 等待调用前已进入缓冲通道的消息全部写入连接，连接断开时返回断开原因，ctx结束时返回ctx的错误，
+// 等待期间依然可以发送新消息
+func (wsc *Wsc) Flush(ctx context.Context) error {
+	wsc.WebSocket.connMu.RLock()
+	connected := wsc.WebSocket.isConnected
+	sendChan, prioChan, done := wsc.WebSocket.sendChan, wsc.WebSocket.prioChan, wsc.WebSocket.done
+	wsc.WebSocket.connMu.RUnlock()
+	if !connected {
+		return wsc.lastCloseErr()
+	}
+	return wsc.flush(ctx, done, prioChan, sendChan)
+}
+
+// flush 依次向缓冲通道放入标记消息并等待写协程处理到该消息，缓冲通道先进先出，标记消息被处理时之前的消息均已发送
+func (wsc *Wsc) flush(ctx context.Context, done chan struct{}, queues ...chan *wsMsg) error {
+	for _, queue := range queues {
+		flushed := make(chan struct{})
+		select {
+		case queue <- &wsMsg{flushed: flushed}:
+		case <-done:
+			return wsc.lastCloseErr()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-flushed:
+		case <-done:
+			return wsc.lastCloseErr()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// stash 保存缓冲通道中尚未发送的消息，写协程可能同时取走消息，调用方需持有connMu
+func (ws *WebSocket) stash() {
+	for _, queue := range []chan *wsMsg{ws.prioChan, ws.sendChan} {
+		for len(queue) > 0 {
+			select {
+			case msg := <-queue:
+				// Flush等待方会因连接断开返回，标记无需保留
+				if msg.flushed == nil {
+					ws.replay = append(ws.replay, msg)
+				}
+			default:
+			}
+		}
+	}
+}
+
+// requeue 将保存的消息放回新的缓冲通道，返回重新入队的数量，调用方需持有connMu
+func (ws *WebSocket) requeue() int {
+	n := 0
+	for _, msg := range ws.replay {
+		select {
+		case ws.queue(msg) <- msg:
+			n++
+		default:
+		}
+	}
+	ws.replay = nil
+	return n
+}
+
+// clean 清理资源，reason为断开原因，返回是否由本次调用完成清理
+func (wsc *Wsc) clean(reason *ClosedError) bool {
+	return wsc.cleanConn(nil, reason)
+}
 
+// cleanConn 清理资源，conn不为nil时仅在其为当前连接时清理，检查和变更状态在同一个锁内完成，
+// 并发调用时只有一个调用方会执行清理并返回true
+func (wsc *Wsc) cleanConn(conn *websocket.Conn, reason *ClosedError) bool {
+	wsc.WebSocket.connMu.Lock()
+	if !wsc.WebSocket.isConnected || (conn != nil && wsc.WebSocket.Conn != conn) {
+		wsc.WebSocket.connMu.Unlock()
+		return false
+	}
+
+	wsc.WebSocket.lastClose = reason
 	wsc.WebSocket.isConnected = false
-	_ = wsc.WebSocket.Conn.Close()
-	close(wsc.WebSocket.sendChan)
+	if wsc.cfg().PersistPending {
+		wsc.WebSocket.stash()
+	}
+	wsc.WebSocket.closeAcked = nil
+	wsc.WebSocket.connected = make(chan struct{})
+	close(wsc.WebSocket.done)
+	current := wsc.WebSocket.Conn
+	wsc.WebSocket.connMu.Unlock()
+
+	// 底层连接关闭可能因网络原因阻塞，不持有锁且最多等待CloseTimeout
+	closed := make(chan struct{})
+	go func() {
+		_ = current.Close()
+		close(closed)
+	}()
+	timer := time.NewTimer(wsc.cfg().closeTimeout())
+	defer timer.Stop()
+	select {
+	case <-closed:
+	case <-timer.C:
+	}
+	return true
+}
+
+// TagSequence 为消息添加序列号标记，格式为"十进制序列号:"前缀
+func TagSequence(seq uint64, data []byte) []byte {
+	tagged := strconv.AppendUint(make([]byte, 0, len(data)+21), seq, 10)
+	tagged = append(tagged, ':')
+	return append(tagged, data...)
+}
+
+// StripSequence 解析并去掉消息的序列号标记，没有标记时返回false
+func StripSequence(data []byte) (seq uint64, payload []byte, ok bool) {
+	i := bytes.IndexByte(data, ':')
+	if i <= 0 {
+		return 0, data, false
+	}
+	seq, err := strconv.ParseUint(string(data[:i]), 10, 64)
+	if err != nil {
+		return 0, data, false
+	}
+	return seq, data[i+1:], true
+}
+
+// Deduplicator 接收方按序列号去重，记住最近window个序列号，用于处理断线重发等至少一次投递造成的重复消息
+type Deduplicator struct {
+	mu     sync.Mutex
+	window int
+	seen   map[uint64]struct{}
+	order  []uint64
+}
+
+// NewDeduplicator 创建去重器，window为记住的序列号数量，需大于重发时可能重复的消息数量
+func NewDeduplicator(window int) *Deduplicator {
+	return &Deduplicator{
+		window: window,
+		seen:   make(map[uint64]struct{}, window),
+	}
+}
+
+// Accept 去掉消息的序列号标记，返回去掉标记后的消息和是否应当处理，重复的消息返回false，
+// 没有标记的消息原样返回并总是处理
+func (d *Deduplicator) Accept(data []byte) ([]byte, bool) {
+	seq, payload, ok := StripSequence(data)
+	if !ok {
+		return data, true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, dup := d.seen[seq]; dup {
+		return payload, false
+	}
+	d.seen[seq] = struct{}{}
+	d.order = append(d.order, seq)
+	// 超出窗口时忘记最早的序列号
+	if len(d.order) > d.window {
+		delete(d.seen, d.order[0])
+		d.order = d.order[1:]
+	}
+	return payload, true
 }
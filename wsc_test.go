@@ -1,8 +1,33 @@
 package wsc
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 func TestConnect(t *testing.T) {
@@ -73,3 +98,5390 @@ func TestConnect(t *testing.T) {
 		return
 	}
 }
+
+// newTestServer 启动本地WebSocket测试服务端，返回ws地址
+func newTestServer(t *testing.T, handler func(conn *websocket.Conn)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	return newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(conn)
+	})
+}
+
+// newHTTPTestServer 启动本地HTTP测试服务端，用于需要检查握手请求的测试，返回ws地址
+func newHTTPTestServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// echoHandler 原样回复收到的消息
+func echoHandler(conn *websocket.Conn) {
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(messageType, message); err != nil {
+			return
+		}
+	}
+}
+
+// drainHandler 只读取消息，保证控制帧回调被触发
+func drainHandler(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func TestSendPong(t *testing.T) {
+	pongs := make(chan string, 1)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		conn.SetPongHandler(func(appData string) error {
+			pongs <- appData
+			return nil
+		})
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendPong([]byte("custom")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case appData := <-pongs:
+		if appData != "custom" {
+			t.Fatalf("unexpected pong data: %q", appData)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not receive pong")
+	}
+}
+
+func TestSendClose(t *testing.T) {
+	codes := make(chan int, 1)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, err := conn.ReadMessage()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			codes <- ce.Code
+		}
+	})
+	ws := New(url)
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendClose(websocket.CloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-codes:
+		if code != websocket.CloseGoingAway {
+			t.Fatalf("unexpected close code: %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not receive close")
+	}
+}
+
+func TestSendTextMessageTTL(t *testing.T) {
+	received := make(chan string, 10)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	})
+	ws := New(url)
+	// 第一条消息发送成功后阻塞写协程
+	release := make(chan struct{})
+	var once sync.Once
+	ws.OnTextMessageSent(func(message []byte) {
+		once.Do(func() { <-release })
+	})
+	expired := make(chan string, 10)
+	ws.OnMessageExpired(func(message []byte) {
+		expired <- string(message)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendTextMessage("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendTextMessageTTL("stale", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendTextMessageTTL("fresh", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	for _, want := range []string{"first", "fresh"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("server did not receive %q", want)
+		}
+	}
+	select {
+	case got := <-expired:
+		if got != "stale" {
+			t.Fatalf("unexpected expired message: %q", got)
+		}
+	default:
+		t.Fatal("expired callback not fired")
+	}
+}
+
+func TestMessages(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	ws := New(url)
+	messages := ws.Messages()
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendTextMessage("text"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendBinaryMessage([]byte("binary")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Message{
+		{Type: websocket.TextMessage, Data: []byte("text")},
+		{Type: websocket.BinaryMessage, Data: []byte("binary")},
+	}
+	var got []Message
+	timeout := time.After(time.Second)
+	for len(got) < len(want) {
+		select {
+		case msg := <-messages:
+			got = append(got, msg)
+		case <-timeout:
+			t.Fatalf("received %d of %d messages", len(got), len(want))
+		}
+	}
+	for i := range want {
+		if got[i].Type != want[i].Type || string(got[i].Data) != string(want[i].Data) {
+			t.Fatalf("message %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	for _, tc := range []struct {
+		size int64
+		want int64
+	}{
+		{0, DefaultMaxMessageSize},
+		{-1, DefaultMaxMessageSize},
+		{16, 16},
+		{UnlimitedMessageSize, UnlimitedMessageSize},
+	} {
+		c := &Config{MaxMessageSize: tc.size}
+		if got := c.readLimit(); got != tc.want {
+			t.Errorf("MaxMessageSize %d: expected %d, got %d", tc.size, tc.want, got)
+		}
+	}
+}
+
+func TestMaxMessageSizeExceeded(t *testing.T) {
+	closeCodes := make(chan int, 1)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		if err := conn.WriteMessage(websocket.TextMessage, make([]byte, 64)); err != nil {
+			return
+		}
+		_, _, err := conn.ReadMessage()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			closeCodes <- ce.Code
+		}
+	})
+	ws := New(url)
+	ws.Config.MaxMessageSize = 16
+	ws.Config.EnableReconnect = false
+	disconnected := make(chan error, 1)
+	ws.OnDisconnected(func(err error) {
+		disconnected <- err
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	select {
+	case err := <-disconnected:
+		if err != websocket.ErrReadLimit {
+			t.Fatalf("expected ErrReadLimit, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("disconnect not reported")
+	}
+	select {
+	case code := <-closeCodes:
+		if code != websocket.CloseMessageTooBig {
+			t.Fatalf("expected close code %d, got %d", websocket.CloseMessageTooBig, code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not receive close frame")
+	}
+}
+
+func TestOnCloseCode(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler func(conn *websocket.Conn)
+		code    int
+	}{
+		{
+			name: "going away",
+			handler: func(conn *websocket.Conn) {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "restart"))
+				drainHandler(conn)
+			},
+			code: websocket.CloseGoingAway,
+		},
+		{
+			name: "policy violation",
+			handler: func(conn *websocket.Conn) {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "restart"))
+				drainHandler(conn)
+			},
+			code: websocket.ClosePolicyViolation,
+		},
+		{
+			name: "abnormal",
+			handler: func(conn *websocket.Conn) {
+				_ = conn.UnderlyingConn().Close()
+			},
+			code: websocket.CloseAbnormalClosure,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			url := newTestServer(t, tc.handler)
+			ws := New(url)
+			ws.Config.EnableReconnect = false
+			codes := make(chan int, 1)
+			ws.OnClose(func(code int, text string) {
+				codes <- code
+			})
+			ws.Connect()
+			defer ws.Close()
+
+			select {
+			case code := <-codes:
+				if code != tc.code {
+					t.Fatalf("expected close code %d, got %d", tc.code, code)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("close not reported")
+			}
+		})
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	accepted := make(chan struct{}, 2)
+	closed := make(chan struct{}, 2)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		accepted <- struct{}{}
+		echoHandler(conn)
+		closed <- struct{}{}
+	})
+	ws := New(url)
+	received := make(chan string, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- string(message)
+	})
+	ws.Connect()
+	defer ws.Close()
+	<-accepted
+
+	ws.Reconnect()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("no new handshake after Reconnect")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("old connection not closed")
+	}
+
+	// 新连接可以正常收发
+	for !ws.IsConnected() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-received:
+		if message != "hello" {
+			t.Fatalf("unexpected message: %q", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no echo on new connection")
+	}
+}
+
+func TestReconnectDuringBackoff(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var handshakes int32
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// 首次握手失败，客户端进入重连等待
+		if atomic.AddInt32(&handshakes, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.MinRecTime = 5 * time.Second
+	ws.Config.MaxRecTime = 5 * time.Second
+	defer ws.Close()
+	go ws.Connect()
+
+	for ws.NextReconnectDelay() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	state := ws.State()
+	start := time.Now()
+	ws.Reconnect()
+	// 唤醒等待中的重连，不另外发起连接
+	if got := ws.State(); got != state {
+		t.Fatalf("expected state %v unchanged, got %v", state, got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ws.WaitConnected(ctx); err != nil {
+		t.Fatalf("Reconnect did not wake backoff wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("reconnected after %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&handshakes); n != 2 {
+		t.Fatalf("expected 2 handshakes, got %d", n)
+	}
+}
+
+func TestWaitConnected(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	upgrader := websocket.Upgrader{}
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		drainHandler(conn)
+	})
+
+	ws := New(url)
+	defer ws.Close()
+	start := time.Now()
+	go ws.Connect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ws.WaitConnected(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ws.WaitConnected(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !ws.IsConnected() {
+		t.Fatal("expected connected")
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("returned before handshake completed: %v", elapsed)
+	}
+}
+
+func TestSendWithDeadline(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	// 服务端不读取数据，使客户端写阻塞
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		<-stop
+	})
+	ws := New(url)
+	ws.Config.WriteWait = time.Minute
+	errs := make(chan error, 1)
+	ws.OnSentError(func(err error) {
+		errs <- err
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendBinaryMessageWithDeadline(make([]byte, 32*1024*1024), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Fatalf("expected timeout error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write did not time out")
+	}
+}
+
+var errPoolFull = errors.New("pool is full")
+
+// limitPool 限制并发任务数的协程池
+type limitPool struct {
+	sem chan struct{}
+}
+
+func newLimitPool(size int) *limitPool {
+	return &limitPool{sem: make(chan struct{}, size)}
+}
+
+func (p *limitPool) Submit(task func()) error {
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		return errPoolFull
+	}
+	go func() {
+		defer func() { <-p.sem }()
+		task()
+	}()
+	return nil
+}
+
+func TestPoolSubmitError(t *testing.T) {
+	url := newTestServer(t, drainHandler)
+	ws := New(url)
+	ws.Config.Pool = newLimitPool(1)
+	errs := make(chan error, 2)
+	ws.OnConnectError(func(err error) {
+		errs <- err
+	})
+	done := make(chan struct{})
+	go func() {
+		ws.Connect()
+		close(done)
+	}()
+	defer ws.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Connect hung on pool submit")
+	}
+	select {
+	case err := <-errs:
+		if err != errPoolFull {
+			t.Fatalf("expected pool error, got %v", err)
+		}
+	default:
+		t.Fatal("pool submit error not reported")
+	}
+}
+
+func TestPoolSubmitFallback(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	ws := New(url)
+	// 只能容纳写协程，读协程提交失败后退回直接开启协程
+	ws.Config.Pool = newLimitPool(1)
+	received := make(chan string, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- string(message)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != "hello" {
+			t.Fatalf("unexpected echo %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection is silent after pool submit failure")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	received := make(chan string, 10)
+	closeCodes := make(chan int, 1)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				if ce, ok := err.(*websocket.CloseError); ok {
+					closeCodes <- ce.Code
+				}
+				return
+			}
+			received <- string(message)
+		}
+	})
+	ws := New(url)
+	ws.Connect()
+
+	want := []string{"a", "b", "c", "logout"}
+	for _, message := range want {
+		if err := ws.SendTextMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ws.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendTextMessage("late"); !errors.Is(err, ErrClose) {
+		t.Fatalf("expected ErrClose after shutdown, got %v", err)
+	}
+
+	for _, message := range want {
+		select {
+		case got := <-received:
+			if got != message {
+				t.Fatalf("expected %q, got %q", message, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("server did not receive %q", message)
+		}
+	}
+	select {
+	case code := <-closeCodes:
+		if code != websocket.CloseNormalClosure {
+			t.Fatalf("unexpected close code %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not receive close frame")
+	}
+}
+
+func TestPriorityBufferLen(t *testing.T) {
+	ws := New(newTestServer(t, drainHandler))
+	ws.Connect()
+	defer ws.Close()
+
+	ws.Pause()
+	if err := ws.SendTextMessagePriority("urgent", PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendTextMessage("normal"); err != nil {
+		t.Fatal(err)
+	}
+	if n := ws.PriorityBufferLen(); n != 1 {
+		t.Fatalf("expected 1 high priority message queued, got %d", n)
+	}
+	if n := ws.BufferLen(); n != 1 {
+		t.Fatalf("expected 1 normal message queued, got %d", n)
+	}
+	ws.Resume()
+	for ws.PriorityBufferLen() != 0 || ws.BufferLen() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBufferFull(t *testing.T) {
+	url := newTestServer(t, drainHandler)
+	ws := New(url)
+	ws.Config.MessageBufferSize = 2
+	// 阻塞写协程
+	release := make(chan struct{})
+	var once sync.Once
+	ws.OnTextMessageSent(func(message []byte) {
+		once.Do(func() { <-release })
+	})
+	var full int32
+	ws.OnBufferFull(func() {
+		atomic.AddInt32(&full, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+	defer close(release)
+
+	if err := ws.SendTextMessage("blocker"); err != nil {
+		t.Fatal(err)
+	}
+	for ws.BufferLen() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < ws.BufferCap(); i++ {
+		if err := ws.SendTextMessage("queued"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ws.BufferLen() != ws.BufferCap() {
+		t.Fatalf("expected full buffer, len %d cap %d", ws.BufferLen(), ws.BufferCap())
+	}
+	if err := ws.SendTextMessage("dropped"); err != ErrBuffer {
+		t.Fatalf("expected ErrBuffer, got %v", err)
+	}
+	if n := atomic.LoadInt32(&full); n != 1 {
+		t.Fatalf("expected OnBufferFull once, got %d", n)
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	const count = 200
+	received := make(chan string, count)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	})
+	ws := New(url)
+	ws.Config.WriteBatchWindow = 5 * time.Millisecond
+	var writes int64
+	ws.Config.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn, writes: &writes}, nil
+	}
+	var sent int32
+	ws.OnTextMessageSent(func(message []byte) {
+		atomic.AddInt32(&sent, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	for i := 0; i < count; i++ {
+		if err := ws.SendTextMessage(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case message := <-received:
+			if message != strconv.Itoa(i) {
+				t.Fatalf("expected %d, got %q", i, message)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d messages", i, count)
+		}
+	}
+	if n := atomic.LoadInt32(&sent); n != count {
+		t.Fatalf("expected %d sent callbacks, got %d", count, n)
+	}
+	// 握手、心跳之外，整批消息合并写入连接
+	if n := atomic.LoadInt64(&writes); n >= count/2 {
+		t.Fatalf("expected batched writes, got %d writes for %d messages", n, count)
+	}
+}
+
+func TestBatchConnCloseFlushes(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	bc := &batchConn{Conn: client}
+	bc.begin()
+	if _, err := bc.Write([]byte("close frame")); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(server)
+		received <- string(data)
+	}()
+	// 批量写入中关闭连接，缓冲的数据先写出
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "close frame" {
+		t.Fatalf("expected buffered data before close, got %q", got)
+	}
+}
+
+func BenchmarkWriteBatch(b *testing.B) {
+	for _, window := range []time.Duration{0, time.Millisecond} {
+		b.Run("window="+window.String(), func(b *testing.B) {
+			upgrader := websocket.Upgrader{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				drainHandler(conn)
+			}))
+			defer srv.Close()
+
+			ws := New("ws" + strings.TrimPrefix(srv.URL, "http"))
+			ws.Config.WriteBatchWindow = window
+			ws.Config.MessageBufferSize = 1024
+			// 统计写入底层连接的次数
+			var writes int64
+			ws.Config.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return &countingConn{Conn: conn, writes: &writes}, nil
+			}
+			var sent int64
+			ws.OnTextMessageSent(func(message []byte) {
+				atomic.AddInt64(&sent, 1)
+			})
+			ws.Connect()
+			defer ws.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for ws.SendTextMessage("tick") == ErrBuffer {
+					runtime.Gosched()
+				}
+			}
+			for atomic.LoadInt64(&sent) < int64(b.N) {
+				runtime.Gosched()
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&writes))/float64(b.N), "writes/op")
+		})
+	}
+}
+
+// countingConn 统计Write调用次数的连接
+type countingConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+func BenchmarkWriteBufferPool(b *testing.B) {
+	for _, pooled := range []bool{false, true} {
+		b.Run("pooled="+strconv.FormatBool(pooled), func(b *testing.B) {
+			upgrader := websocket.Upgrader{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				drainHandler(conn)
+			}))
+			defer srv.Close()
+			url := "ws" + strings.TrimPrefix(srv.URL, "http")
+			// 所有客户端共享同一个池
+			pool := &sync.Pool{}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ws := New(url)
+				ws.Config.WriteBufferSize = 64 * 1024
+				if pooled {
+					ws.Config.WriteBufferPool = pool
+				}
+				sent := make(chan struct{})
+				ws.OnTextMessageSent(func(message []byte) {
+					close(sent)
+				})
+				ws.Connect()
+				if err := ws.SendTextMessage("tick"); err != nil {
+					b.Fatal(err)
+				}
+				<-sent
+				ws.Close()
+			}
+		})
+	}
+}
+
+func TestStrictOrdering(t *testing.T) {
+	const count = 1000
+	received := make(chan string, count)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(message) != "noise" {
+				received <- string(message)
+			}
+		}
+	})
+	ws := New(url)
+	ws.Config.StrictOrdering = true
+	ws.Config.WriteBatchWindow = time.Millisecond
+	ws.Connect()
+	defer ws.Close()
+
+	// 其他协程并发发送制造负载
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					_ = ws.SendTextMessage("noise")
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	// 穿插发送的高优先级消息也按调用顺序发送
+	for i := 0; i < count; i++ {
+		priority := PriorityNormal
+		if i%3 == 0 {
+			priority = PriorityHigh
+		}
+		for {
+			err := ws.SendTextMessagePriority(strconv.Itoa(i), priority)
+			if err == nil {
+				break
+			}
+			if err != ErrBuffer {
+				t.Fatal(err)
+			}
+			runtime.Gosched()
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	for i := 0; i < count; i++ {
+		select {
+		case message := <-received:
+			if message != strconv.Itoa(i) {
+				t.Fatalf("expected %d, got %q", i, message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d messages", i, count)
+		}
+	}
+}
+
+func TestStrictOrderingPaused(t *testing.T) {
+	const count = 100
+	received := make(chan string, count)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	})
+	ws := New(url)
+	ws.Config.StrictOrdering = true
+	ws.Config.MessageBufferSize = count
+	ws.Connect()
+	defer ws.Close()
+
+	// 暂停时全部入队，恢复后高优先级消息也不会越过先入队的消息
+	ws.Pause()
+	for i := 0; i < count; i++ {
+		priority := PriorityNormal
+		if i%3 == 0 {
+			priority = PriorityHigh
+		}
+		if err := ws.SendTextMessagePriority(strconv.Itoa(i), priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws.Resume()
+
+	for i := 0; i < count; i++ {
+		select {
+		case message := <-received:
+			if message != strconv.Itoa(i) {
+				t.Fatalf("expected %d, got %q", i, message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d messages", i, count)
+		}
+	}
+}
+
+type traceKey struct{}
+
+func TestHeaderFunc(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upgrader := websocket.Upgrader{}
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.WebSocket.RequestHeader.Set("X-Static", "static")
+	ws.Config.HeaderFunc = func(ctx context.Context) http.Header {
+		header := http.Header{}
+		if traceparent, ok := ctx.Value(traceKey{}).(string); ok {
+			header.Set("traceparent", traceparent)
+			header.Set("tracestate", "wsc=1")
+		}
+		return header
+	}
+	ctx := context.WithValue(context.Background(), traceKey{}, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if err := ws.ConnectContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	header := <-headers
+	if got := header.Get("traceparent"); got != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Fatalf("unexpected traceparent %q", got)
+	}
+	if got := header.Get("tracestate"); got != "wsc=1" {
+		t.Fatalf("unexpected tracestate %q", got)
+	}
+	if got := header.Get("X-Static"); got != "static" {
+		t.Fatalf("unexpected static header %q", got)
+	}
+	if ws.WebSocket.RequestHeader.Get("traceparent") != "" {
+		t.Fatal("RequestHeader should not be modified")
+	}
+}
+
+func TestConnectContextCancel(t *testing.T) {
+	ws := New("ws://127.0.0.1:1")
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := ws.ConnectContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestConnectErrorDecision(t *testing.T) {
+	var dials int32
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&dials, 1)
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	ws := New(url)
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	var attempts []int
+	ws.OnConnectErrorDecision(func(err error, attempt int) bool {
+		attempts = append(attempts, attempt)
+		return false
+	})
+	if err := ws.ConnectContext(context.Background()); !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Fatalf("expected ErrBadHandshake, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("expected 1 dial, got %d", n)
+	}
+	if len(attempts) != 1 || attempts[0] != 1 {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+}
+
+func TestHandshakeError(t *testing.T) {
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+	})
+	ws := New(url)
+	errs := make(chan error, 1)
+	ws.OnConnectErrorDecision(func(err error, attempt int) bool {
+		errs <- err
+		return false
+	})
+	_ = ws.ConnectContext(context.Background())
+
+	err := <-errs
+	var handshakeErr *HandshakeError
+	if !errors.As(err, &handshakeErr) {
+		t.Fatalf("expected HandshakeError, got %v", err)
+	}
+	if handshakeErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status %d", handshakeErr.StatusCode)
+	}
+	if got := handshakeErr.Header.Get("Retry-After"); got != "30" {
+		t.Fatalf("unexpected Retry-After %q", got)
+	}
+	if !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Fatal("expected errors.Is ErrBadHandshake")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+		ok    bool
+	}{
+		{"", 0, 0, false},
+		{"2", 2 * time.Second, 2 * time.Second, true},
+		{"-1", 0, 0, false},
+		{"soon", 0, 0, false},
+		{time.Now().Add(3 * time.Second).UTC().Format(http.TimeFormat), time.Second, 3 * time.Second, true},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0, true},
+	} {
+		e := &HandshakeError{Header: http.Header{}}
+		e.Header.Set("Retry-After", tc.value)
+		d, ok := e.RetryAfter()
+		if ok != tc.ok || d < tc.min || d > tc.max {
+			t.Errorf("Retry-After %q: got %v %v", tc.value, d, ok)
+		}
+	}
+}
+
+func TestRetryAfterBackoff(t *testing.T) {
+	var dials []time.Time
+	var mu sync.Mutex
+	upgrader := websocket.Upgrader{}
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		dials = append(dials, time.Now())
+		first := len(dials) == 1
+		mu.Unlock()
+		if first {
+			w.Header().Set("Retry-After", "2")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	ws.Connect()
+	defer ws.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dials) != 2 {
+		t.Fatalf("expected 2 dials, got %d", len(dials))
+	}
+	if delay := dials[1].Sub(dials[0]); delay < 1900*time.Millisecond || delay > 3*time.Second {
+		t.Fatalf("expected retry after about 2s, got %v", delay)
+	}
+}
+
+func TestSendBinaryReader(t *testing.T) {
+	received := make(chan []byte, 1)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil || messageType != websocket.BinaryMessage {
+			return
+		}
+		received <- message
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Connect()
+	defer ws.Close()
+
+	payload := make([]byte, 8*1024*1024)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendBinaryReader(bytes.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-received:
+		if !bytes.Equal(message, payload) {
+			t.Fatal("received payload differs")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not receive payload")
+	}
+}
+
+// testAbortedSend 读取消息数据失败时，对端不会收到被截断的完整消息，连接断开后重连
+func testAbortedSend(t *testing.T, send func(ws *Wsc, r io.Reader) error) {
+	t.Helper()
+	results := make(chan string, 2)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			results <- "error"
+			return
+		}
+		results <- string(message)
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	sendErrs := make(chan error, 1)
+	ws.OnSentError(func(err error) {
+		sendErrs <- err
+	})
+	var connects int32
+	ws.OnConnected(func() {
+		atomic.AddInt32(&connects, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	r := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("disk failure")))
+	if err := send(ws, r); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-sendErrs:
+		if !errors.Is(err, ErrReaderAborted) {
+			t.Fatalf("expected ErrReaderAborted, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send error not reported")
+	}
+	select {
+	case got := <-results:
+		if got != "error" {
+			t.Fatalf("server received truncated message %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection not dropped")
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&connects) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("not reconnected after aborted message")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSendReaderError(t *testing.T) {
+	testAbortedSend(t, func(ws *Wsc, r io.Reader) error {
+		return ws.SendBinaryReader(r)
+	})
+}
+
+func TestStreamReads(t *testing.T) {
+	payload := make([]byte, 8*1024*1024)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+			return
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.StreamReads = true
+	sums := make(chan []byte, 1)
+	ws.OnMessageStream(func(messageType int, r io.Reader) {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return
+		}
+		sums <- h.Sum(nil)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	want := sha256.Sum256(payload)
+	select {
+	case sum := <-sums:
+		if !bytes.Equal(sum, want[:]) {
+			t.Fatal("checksum mismatch")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not received")
+	}
+}
+
+func TestPauseIdleWriter(t *testing.T) {
+	received := make(chan string, 10)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	})
+	ws := New(url)
+	ws.Connect()
+	defer ws.Close()
+
+	// 写协程已阻塞等待消息后再暂停
+	time.Sleep(50 * time.Millisecond)
+	ws.Pause()
+	if err := ws.SendTextMessage("a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-received:
+		t.Fatalf("received %q while paused", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ws.Resume()
+	select {
+	case got := <-received:
+		if got != "a" {
+			t.Fatalf("expected a, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not sent after Resume")
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	received := make(chan string, 10)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	})
+	ws := New(url)
+	ws.Config.KeepaliveTime = 50 * time.Millisecond
+	keepalive := make(chan struct{}, 1)
+	ws.OnKeepalive(func() {
+		select {
+		case keepalive <- struct{}{}:
+		default:
+		}
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	ws.Pause()
+	want := []string{"a", "b", "c"}
+	for _, message := range want {
+		if err := ws.SendTextMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case message := <-received:
+		t.Fatalf("received %q while paused", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+	select {
+	case <-keepalive:
+	case <-time.After(time.Second):
+		t.Fatal("keepalive blocked while paused")
+	}
+	if !ws.IsConnected() {
+		t.Fatal("expected connection to stay up while paused")
+	}
+
+	ws.Resume()
+	for _, message := range want {
+		select {
+		case got := <-received:
+			if got != message {
+				t.Fatalf("expected %q, got %q", message, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("server did not receive %q", message)
+		}
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+	subs := make(chan string, 4)
+	var conns int32
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		subs <- string(message)
+		// 第一个连接收到订阅后异常断开
+		if atomic.AddInt32(&conns, 1) == 1 {
+			_ = conn.UnderlyingConn().Close()
+			return
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	var calls int32
+	ws.Resubscribe(func() error {
+		atomic.AddInt32(&calls, 1)
+		return ws.SendTextMessage("subscribe")
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case message := <-subs:
+			if message != "subscribe" {
+				t.Fatalf("unexpected message %q", message)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("subscription %d not received", i+1)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected 2 resubscribe calls, got %d", n)
+	}
+}
+
+func TestCloseRace(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		drop := make(chan struct{})
+		url := newTestServer(t, func(conn *websocket.Conn) {
+			<-drop
+			_ = conn.UnderlyingConn().Close()
+		})
+		ws := New(url)
+		ws.Config.EnableReconnect = false
+		var closes int32
+		ws.OnClose(func(code int, text string) {
+			atomic.AddInt32(&closes, 1)
+		})
+		ws.Connect()
+
+		// 服务端断开和客户端主动关闭同时发生
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			close(drop)
+		}()
+		go func() {
+			defer wg.Done()
+			ws.Close()
+		}()
+		wg.Wait()
+		time.Sleep(10 * time.Millisecond)
+
+		if n := atomic.LoadInt32(&closes); n != 1 {
+			t.Fatalf("iteration %d: expected exactly one close, got %d", i, n)
+		}
+		if ws.IsConnected() {
+			t.Fatalf("iteration %d: expected disconnected", i)
+		}
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	// 服务端不再响应
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		<-stop
+	})
+	ws := New(url)
+	ws.Config.ReadTimeout = 200 * time.Millisecond
+	ws.Config.EnableReconnect = false
+	disconnected := make(chan error, 1)
+	ws.OnDisconnected(func(err error) {
+		disconnected <- err
+	})
+	start := time.Now()
+	ws.Connect()
+	defer ws.Close()
+
+	select {
+	case err := <-disconnected:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Fatalf("expected timeout error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Fatalf("disconnected too early: %v", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("disconnect not detected")
+	}
+}
+
+func TestPongKeepsConnectionAlive(t *testing.T) {
+	const readTimeout = 300 * time.Millisecond
+	stopReading := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		// 读取期间由默认处理回复pong
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		<-stopReading
+		// 停止回复pong
+		_ = conn.UnderlyingConn().(*net.TCPConn).CloseRead()
+		<-stop
+	})
+	ws := New(url)
+	ws.Config.ReadTimeout = readTimeout
+	ws.Config.KeepaliveTime = 50 * time.Millisecond
+	ws.Config.EnableReconnect = false
+	disconnected := make(chan time.Time, 1)
+	ws.OnDisconnected(func(err error) {
+		disconnected <- time.Now()
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	select {
+	case <-disconnected:
+		t.Fatal("disconnected while pongs were arriving")
+	case <-time.After(3 * readTimeout):
+	}
+
+	stopped := time.Now()
+	close(stopReading)
+	select {
+	case at := <-disconnected:
+		if elapsed := at.Sub(stopped); elapsed > 3*readTimeout {
+			t.Fatalf("disconnect detected too late: %v", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("disconnect not detected after pongs stopped")
+	}
+}
+
+func TestNewWithConfigValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+	}{
+		{"negative WriteWait", Config{WriteWait: -time.Second}},
+		{"negative MinRecTime", Config{MinRecTime: -time.Second}},
+		{"MaxRecTime less than MinRecTime", Config{MinRecTime: 10 * time.Second, MaxRecTime: time.Second}},
+		{"RecFactor less than 1", Config{RecFactor: 0.5}},
+		{"RecFactor equal to 1", Config{RecFactor: 1}},
+		{"negative MessageBufferSize", Config{MessageBufferSize: -1}},
+		{"negative KeepaliveTime", Config{KeepaliveTime: -time.Second}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ws, err := NewWithConfig("ws://127.0.0.1", &tc.cfg)
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("expected ErrInvalidConfig, got %v", err)
+			}
+			if ws != nil {
+				t.Fatal("expected nil client")
+			}
+		})
+	}
+}
+
+func TestNewWithConfigDefaults(t *testing.T) {
+	cfg := &Config{
+		WriteWait:        time.Second,
+		MaxRecTime:       10 * time.Second,
+		EnableReconnect:  true,
+		ReconnectJitter:  true,
+		RecoverCallbacks: true,
+	}
+	ws, err := NewWithConfig("ws://127.0.0.1", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultConfig()
+	want.WriteWait = time.Second
+	want.MaxRecTime = 10 * time.Second
+	if !reflect.DeepEqual(ws.Config, want) {
+		t.Fatalf("expected %+v, got %+v", *want, *ws.Config)
+	}
+	if cfg.MinRecTime != 0 {
+		t.Fatal("cfg should not be modified")
+	}
+}
+
+func TestNewWithNilConfig(t *testing.T) {
+	ws, err := NewWithConfig("ws://127.0.0.1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ws.Config, defaultConfig()) {
+		t.Fatalf("expected default config, got %+v", *ws.Config)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		modify  func(c *Config)
+		problem string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"WriteWait", func(c *Config) { c.WriteWait = -1 }, "WriteWait"},
+		{"MinRecTime", func(c *Config) { c.MinRecTime = -1 }, "MinRecTime"},
+		{"MaxRecTime", func(c *Config) { c.MaxRecTime = time.Second }, "MaxRecTime"},
+		{"RecFactor", func(c *Config) { c.RecFactor = 1 }, "RecFactor"},
+		{"MessageBufferSize", func(c *Config) { c.MessageBufferSize = 0 }, "MessageBufferSize"},
+		{"KeepaliveTime", func(c *Config) { c.KeepaliveTime = 0 }, "KeepaliveTime"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig()
+			tc.modify(c)
+			err := c.Validate()
+			if tc.problem == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) || !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("expected ConfigError, got %v", err)
+			}
+			if len(configErr.Problems) != 1 || !strings.HasPrefix(configErr.Problems[0], tc.problem) {
+				t.Fatalf("unexpected problems %v", configErr.Problems)
+			}
+		})
+	}
+}
+
+func TestConfigValidateAllProblems(t *testing.T) {
+	c := defaultConfig()
+	c.RecFactor = 0
+	c.MessageBufferSize = -1
+	var configErr *ConfigError
+	if !errors.As(c.Validate(), &configErr) || len(configErr.Problems) != 2 {
+		t.Fatalf("expected two problems, got %v", configErr)
+	}
+}
+
+func TestConnectInvalidConfig(t *testing.T) {
+	ws := New("ws://127.0.0.1:1")
+	ws.Config.MessageBufferSize = 0
+	errs := make(chan error, 1)
+	ws.OnConnectError(func(err error) {
+		errs <- err
+	})
+	if err := ws.ConnectContext(context.Background()); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if err := <-errs; !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig in callback, got %v", err)
+	}
+}
+
+// newBlockedClient 创建一个写协程被阻塞且缓冲通道已满的客户端，关闭release后恢复发送
+func newBlockedClient(t *testing.T, url string, release chan struct{}) *Wsc {
+	t.Helper()
+	ws := New(url)
+	ws.Config.MessageBufferSize = 1
+	var once sync.Once
+	ws.OnBinaryMessageSent(func(data []byte) {
+		once.Do(func() { <-release })
+	})
+	ws.Connect()
+	if err := ws.SendBinaryMessage([]byte("blocker")); err != nil {
+		t.Fatal(err)
+	}
+	for ws.BufferLen() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := ws.SendBinaryMessage([]byte("queued")); err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestSendBinaryMessageBlocking(t *testing.T) {
+	received := make(chan string, 10)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	})
+	release := make(chan struct{})
+	ws := newBlockedClient(t, url, release)
+	defer ws.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- ws.SendBinaryMessageBlocking(context.Background(), []byte("blocking"))
+	}()
+	select {
+	case err := <-result:
+		t.Fatalf("send returned before buffer freed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked send did not proceed")
+	}
+	for _, want := range []string{"blocker", "queued", "blocking"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("server did not receive %q", want)
+		}
+	}
+}
+
+func TestSendBinaryMessageBlockingCancel(t *testing.T) {
+	url := newTestServer(t, drainHandler)
+	release := make(chan struct{})
+	defer close(release)
+	ws := newBlockedClient(t, url, release)
+	defer ws.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ws.SendBinaryMessageBlocking(ctx, []byte("blocking")); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// 断开连接时阻塞的发送返回ErrClose
+	result := make(chan error, 1)
+	go func() {
+		result <- ws.SendBinaryMessageBlocking(context.Background(), []byte("blocking"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	ws.Close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrClose) {
+			t.Fatalf("expected ErrClose, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked send not released on close")
+	}
+}
+
+func TestClosedError(t *testing.T) {
+	t.Run("never connected", func(t *testing.T) {
+		ws := New("ws://127.0.0.1:1")
+		err := ws.SendTextMessage("hello")
+		if err != ErrClose {
+			t.Fatalf("expected ErrClose, got %v", err)
+		}
+		var closedErr *ClosedError
+		if errors.As(err, &closedErr) {
+			t.Fatal("expected no close info before connecting")
+		}
+	})
+
+	for _, tc := range []struct {
+		name    string
+		handler func(conn *websocket.Conn)
+		close   bool
+		code    int
+		text    string
+		wrapped bool
+	}{
+		{"closed by client", drainHandler, true, websocket.CloseNormalClosure, "bye", false},
+		{
+			name: "closed by server",
+			handler: func(conn *websocket.Conn) {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "kicked"))
+				drainHandler(conn)
+			},
+			code:    4001,
+			text:    "kicked",
+			wrapped: true,
+		},
+		{
+			name: "dropped",
+			handler: func(conn *websocket.Conn) {
+				_ = conn.UnderlyingConn().Close()
+			},
+			code:    websocket.CloseAbnormalClosure,
+			text:    io.ErrUnexpectedEOF.Error(),
+			wrapped: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			url := newTestServer(t, tc.handler)
+			ws := New(url)
+			ws.Config.EnableReconnect = false
+			ws.Connect()
+			if tc.close {
+				ws.CloseWithMsg("bye")
+			}
+			for ws.IsConnected() {
+				time.Sleep(time.Millisecond)
+			}
+
+			err := ws.SendTextMessage("hello")
+			if !errors.Is(err, ErrClose) {
+				t.Fatalf("expected ErrClose, got %v", err)
+			}
+			var closedErr *ClosedError
+			if !errors.As(err, &closedErr) {
+				t.Fatalf("expected ClosedError, got %v", err)
+			}
+			if closedErr.Code != tc.code || closedErr.Text != tc.text {
+				t.Fatalf("unexpected close info %d %q", closedErr.Code, closedErr.Text)
+			}
+			var wsCloseErr *websocket.CloseError
+			if errors.As(err, &wsCloseErr) != tc.wrapped {
+				t.Fatalf("unexpected underlying error %v", closedErr.Err)
+			}
+		})
+	}
+}
+
+// pipeListener 基于内存管道的net.Listener
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// DialContext 创建一对内存管道，一端交给服务端
+func (l *pipeListener) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestNetDialContext(t *testing.T) {
+	listener := newPipeListener()
+	upgrader := websocket.Upgrader{}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		echoHandler(conn)
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	ws := New("ws://pipe/")
+	ws.Config.NetDialContext = listener.DialContext
+	received := make(chan string, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- string(message)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendTextMessage("in-memory"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-received:
+		if message != "in-memory" {
+			t.Fatalf("unexpected message %q", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no echo over in-memory connection")
+	}
+	if websocket.DefaultDialer.NetDialContext != nil {
+		t.Fatal("default dialer should not be modified")
+	}
+}
+
+func TestOnMessage(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	ws := New(url)
+	events := make(chan string, 4)
+	ws.OnMessage(func(messageType int, data []byte) {
+		events <- fmt.Sprintf("any:%d:%s", messageType, data)
+	})
+	ws.OnTextMessageReceived(func(message []byte) {
+		events <- "text:" + string(message)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{fmt.Sprintf("any:%d:hello", websocket.TextMessage), "text:hello"} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("callback %q not fired", want)
+		}
+	}
+}
+
+func TestRegisterCallbacksAfterConnect(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	ws := New(url)
+	ws.Connect()
+	defer ws.Close()
+
+	var received int32
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ws.OnTextMessageReceived(func(message []byte) {
+				atomic.AddInt32(&received, 1)
+			})
+			ws.OnTextMessageSent(func(message []byte) {})
+			ws.OnKeepalive(func() {})
+			runtime.Gosched()
+		}
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&received) < 10 && time.Now().Before(deadline) {
+		ws.SendTextMessage("hello")
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+	if atomic.LoadInt32(&received) < 10 {
+		t.Fatalf("expected callbacks registered after Connect to fire, got %d", received)
+	}
+}
+
+func TestReconnectResetInterval(t *testing.T) {
+	// 前3次连接很快断开，第4次连接保持健康超过重置时间后断开
+	var count int32
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		switch n := atomic.AddInt32(&count, 1); {
+		case n <= 3:
+			time.Sleep(10 * time.Millisecond)
+		case n == 4:
+			time.Sleep(300 * time.Millisecond)
+		default:
+			echoHandler(conn)
+		}
+	})
+	ws, err := NewWithConfig(url, &Config{
+		MinRecTime:             100 * time.Millisecond,
+		MaxRecTime:             10 * time.Second,
+		RecFactor:              2,
+		EnableReconnect:        true,
+		ReconnectResetInterval: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var disconnectedAt time.Time
+	var gaps []time.Duration
+	connected := make(chan struct{}, 8)
+	ws.OnDisconnected(func(err error) {
+		mu.Lock()
+		disconnectedAt = time.Now()
+		mu.Unlock()
+	})
+	ws.OnConnected(func() {
+		mu.Lock()
+		if !disconnectedAt.IsZero() {
+			gaps = append(gaps, time.Since(disconnectedAt))
+		}
+		mu.Unlock()
+		connected <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-connected:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection %d not established", i+1)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// 闪断后按退避等待，间隔不小于MinRecTime
+	for i, gap := range gaps[:3] {
+		if gap < 100*time.Millisecond {
+			t.Fatalf("reconnect %d after short-lived connection waited only %v", i+1, gap)
+		}
+	}
+	// 健康连接断开后退避被重置，立即重连
+	if gaps[3] >= 100*time.Millisecond {
+		t.Fatalf("reconnect after healthy connection waited %v, expected backoff reset", gaps[3])
+	}
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		policy OverflowPolicy
+		err    error
+		want   []string
+	}{
+		{DropNewest, ErrBuffer, []string{"blocker", "queued"}},
+		{DropOldest, nil, []string{"blocker", "new"}},
+		{Block, nil, []string{"blocker", "queued", "new"}},
+	}
+	for _, tt := range tests {
+		received := make(chan string, 10)
+		url := newTestServer(t, func(conn *websocket.Conn) {
+			for {
+				_, message, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				received <- string(message)
+			}
+		})
+		release := make(chan struct{})
+		ws := newBlockedClient(t, url, release)
+		ws.Config.OverflowPolicy = tt.policy
+		var full int32
+		ws.OnBufferFull(func() {
+			atomic.AddInt32(&full, 1)
+		})
+
+		result := make(chan error, 1)
+		go func() {
+			result <- ws.SendBinaryMessage([]byte("new"))
+		}()
+		if tt.policy == Block {
+			select {
+			case err := <-result:
+				t.Fatalf("policy %d: send returned before buffer freed: %v", tt.policy, err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(release)
+		}
+		select {
+		case err := <-result:
+			if err != tt.err {
+				t.Fatalf("policy %d: expected %v, got %v", tt.policy, tt.err, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("policy %d: send did not return", tt.policy)
+		}
+		if tt.policy != Block {
+			close(release)
+		}
+		// 每种策略都在缓冲通道已满时通知一次
+		if n := atomic.LoadInt32(&full); n != 1 {
+			t.Fatalf("policy %d: expected OnBufferFull once, got %d", tt.policy, n)
+		}
+		for _, want := range tt.want {
+			select {
+			case got := <-received:
+				if got != want {
+					t.Fatalf("policy %d: expected %q, got %q", tt.policy, want, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("policy %d: server did not receive %q", tt.policy, want)
+			}
+		}
+		select {
+		case got := <-received:
+			t.Fatalf("policy %d: unexpected message %q", tt.policy, got)
+		case <-time.After(50 * time.Millisecond):
+		}
+		ws.Close()
+	}
+}
+
+func TestCloseWithCode(t *testing.T) {
+	codes := make(chan *websocket.CloseError, 1)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_, _, err := conn.ReadMessage()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			codes <- ce
+		}
+	})
+	ws := New(url)
+	var closed string
+	ws.OnClose(func(code int, text string) {
+		closed = fmt.Sprintf("%d:%s", code, text)
+	})
+	ws.Connect()
+
+	for _, code := range []int{999, 1005, 1006, 1015, 2000, 5000} {
+		if err := ws.CloseWithCode(code, "bad"); !errors.Is(err, ErrInvalidCloseCode) {
+			t.Fatalf("code %d: expected ErrInvalidCloseCode, got %v", code, err)
+		}
+	}
+	if !ws.IsConnected() {
+		t.Fatal("invalid close code closed the connection")
+	}
+
+	if err := ws.CloseWithCode(4001, "session expired"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ce := <-codes:
+		if ce.Code != 4001 || ce.Text != "session expired" {
+			t.Fatalf("unexpected close frame: %d %q", ce.Code, ce.Text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not receive close")
+	}
+	if closed != "4001:session expired" {
+		t.Fatalf("unexpected OnClose: %q", closed)
+	}
+	if ws.IsConnected() {
+		t.Fatal("still connected after CloseWithCode")
+	}
+	var closedErr *ClosedError
+	if err := ws.CloseWithCode(websocket.CloseNormalClosure, ""); !errors.As(err, &closedErr) || closedErr.Code != 4001 {
+		t.Fatalf("expected last close reason, got %v", err)
+	}
+}
+
+func TestSetDialer(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	var dials int32
+	ws := New(url)
+	ws.SetDialer(&websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	})
+	ws.Connect()
+	defer ws.Close()
+	if !ws.IsConnected() {
+		t.Fatal("not connected with custom dialer")
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("expected custom dialer to be used once, got %d", n)
+	}
+
+	// 恢复默认Dialer后重连不再使用自定义Dialer
+	ws.SetDialer(nil)
+	ws.Reconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ws.WaitConnected(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("custom dialer used after reset, got %d dials", n)
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var handshakes int32
+	accepted := make(chan string, 2)
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		header := http.Header{}
+		if atomic.AddInt32(&handshakes, 1) == 1 {
+			// 首次握手下发会话Cookie
+			header.Set("Set-Cookie", "session=abc; Path=/")
+		} else if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "abc" {
+			http.Error(w, "missing session", http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		accepted <- r.Header.Get("Cookie")
+		drainHandler(conn)
+	})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := New(url)
+	ws.Config.CookieJar = jar
+	ws.Connect()
+	defer ws.Close()
+	<-accepted
+
+	ws.Reconnect()
+	select {
+	case cookie := <-accepted:
+		if cookie != "session=abc" {
+			t.Fatalf("unexpected cookie %q", cookie)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reconnect without session cookie was rejected")
+	}
+	if websocket.DefaultDialer.Jar != nil {
+		t.Fatal("default dialer should not be modified")
+	}
+}
+
+func TestOnConnectedResponse(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, http.Header{"X-Connection-Id": {"conn-42"}})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		drainHandler(conn)
+	})
+	ws := New(url)
+	var id string
+	var connected bool
+	ws.OnConnectedResponse(func(resp *http.Response) {
+		id = resp.Header.Get("X-Connection-Id")
+		connected = ws.IsConnected()
+	})
+	ws.Connect()
+	defer ws.Close()
+	if id != "conn-42" {
+		t.Fatalf("unexpected connection id %q", id)
+	}
+	if !connected {
+		t.Fatal("OnConnectedResponse fired before connected")
+	}
+}
+
+func TestFallbackURLs(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := "ws" + strings.TrimPrefix(down.URL, "http")
+	down.Close()
+	url := newTestServer(t, drainHandler)
+
+	ws := New(downURL)
+	ws.Config.MinRecTime = time.Second
+	ws.Config.FallbackURLs = []string{url}
+	var errs int32
+	ws.OnConnectError(func(err error) {
+		atomic.AddInt32(&errs, 1)
+	})
+	start := time.Now()
+	ws.Connect()
+	defer ws.Close()
+
+	if !ws.IsConnected() {
+		t.Fatal("not connected to fallback")
+	}
+	if got := ws.ConnectedURL(); got != url {
+		t.Fatalf("expected connected url %q, got %q", url, got)
+	}
+	if n := atomic.LoadInt32(&errs); n != 1 {
+		t.Fatalf("expected 1 connect error for the down primary, got %d", n)
+	}
+	// 还有备用地址时不等待退避
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("failover waited for backoff: %v", elapsed)
+	}
+}
+
+func TestReconnectJitter(t *testing.T) {
+	ws := New("ws://127.0.0.1:0")
+	ws.Config.MinRecTime = 100 * time.Millisecond
+	ws.Config.MaxRecTime = time.Second
+	ws.Config.RecFactor = 2
+	ws.Config.ReconnectJitter = false
+	b := ws.backoff()
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, d := range want {
+		if got := b.Duration(); got != d {
+			t.Fatalf("retry %d: expected %v, got %v", i+1, d, got)
+		}
+	}
+
+	// 默认开启抖动
+	if !New("ws://127.0.0.1:0").Config.ReconnectJitter {
+		t.Fatal("jitter should be enabled by default")
+	}
+}
+
+func TestReconnectAccessors(t *testing.T) {
+	// 首次连接后立即断开，之后拒绝握手
+	var handshakes int32
+	upgrader := websocket.Upgrader{}
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&handshakes, 1) > 1 {
+			http.Error(w, "refused", http.StatusInternalServerError)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	})
+	ws := New(url)
+	ws.Config.MinRecTime = 100 * time.Millisecond
+	ws.Config.RecFactor = 2
+	ws.Config.ReconnectJitter = false
+	connected := make(chan struct{}, 1)
+	ws.OnConnected(func() {
+		if ws.ReconnectAttempts() != 0 || ws.NextReconnectDelay() != 0 {
+			t.Errorf("accessors not zero when connected: %d %v", ws.ReconnectAttempts(), ws.NextReconnectDelay())
+		}
+		connected <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+	<-connected
+
+	for attempt, delay := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		deadline := time.Now().Add(2 * time.Second)
+		for ws.ReconnectAttempts() <= attempt || ws.NextReconnectDelay() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("attempt %d not reached, attempts %d", attempt+1, ws.ReconnectAttempts())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if got := ws.NextReconnectDelay(); got != delay {
+			t.Fatalf("attempt %d: expected delay %v, got %v", attempt+1, delay, got)
+		}
+	}
+}
+
+func TestOnMessageTooBig(t *testing.T) {
+	// 服务端每次连接都发送超长消息
+	var handshakes int32
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		atomic.AddInt32(&handshakes, 1)
+		if err := conn.WriteMessage(websocket.BinaryMessage, make([]byte, 64)); err != nil {
+			return
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.MaxMessageSize = 16
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	limits := make(chan int64, 10)
+	ws.OnMessageTooBig(func(limit int64) {
+		limits <- limit
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	select {
+	case limit := <-limits:
+		if limit != 16 {
+			t.Fatalf("expected limit 16, got %d", limit)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnMessageTooBig not fired")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&handshakes); n != 1 {
+		t.Fatalf("expected no reconnect after oversized message, got %d handshakes", n)
+	}
+	if ws.IsConnected() {
+		t.Fatal("still connected after oversized message")
+	}
+}
+
+// brokenWriteConn 读正常但可以让写入失败的连接
+type brokenWriteConn struct {
+	net.Conn
+	broken *int32
+}
+
+func (c *brokenWriteConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(c.broken) == 1 {
+		return 0, &net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE}
+	}
+	return c.Conn.Write(b)
+}
+
+func TestFatalWriteErrorReconnects(t *testing.T) {
+	accepted := make(chan struct{}, 2)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		accepted <- struct{}{}
+		drainHandler(conn)
+	})
+	var broken int32
+	ws := New(url)
+	ws.Config.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &brokenWriteConn{Conn: conn, broken: &broken}, nil
+	}
+	disconnected := make(chan error, 1)
+	ws.OnDisconnected(func(err error) {
+		disconnected <- err
+	})
+	ws.Connect()
+	defer ws.Close()
+	<-accepted
+
+	atomic.StoreInt32(&broken, 1)
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-disconnected:
+		var netErr net.Error
+		if !errors.As(err, &netErr) {
+			t.Fatalf("expected write error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fatal write error did not disconnect")
+	}
+	atomic.StoreInt32(&broken, 0)
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("no reconnect after fatal write error")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var received int32
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			atomic.AddInt32(&received, 1)
+		}
+	})
+	ws := New(url)
+	var sent int32
+	ws.OnTextMessageSent(func(message []byte) {
+		// 放慢写协程，保证Flush调用时缓冲通道中还有消息
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&sent, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		if err := ws.SendTextMessage(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ws.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&sent); got != n {
+		t.Fatalf("expected %d messages sent before Flush returned, got %d", n, got)
+	}
+	// 写入连接后服务端很快就能读到
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&received) != n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&received); got != n {
+		t.Fatalf("server received %d of %d messages", got, n)
+	}
+
+	ws.Close()
+	if err := ws.Flush(ctx); !errors.Is(err, ErrClose) {
+		t.Fatalf("expected ErrClose after Close, got %v", err)
+	}
+}
+
+func TestSendTextMessagePriority(t *testing.T) {
+	received := make(chan string, 10)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	})
+	release := make(chan struct{})
+	ws := newBlockedClient(t, url, release)
+	defer ws.Close()
+
+	// 普通缓冲通道已满时高优先级消息依然可以发送
+	if err := ws.SendTextMessage("normal"); err != ErrBuffer {
+		t.Fatalf("expected ErrBuffer, got %v", err)
+	}
+	if err := ws.SendTextMessagePriority("urgent", PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	for _, want := range []string{"blocker", "urgent", "queued"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("server did not receive %q", want)
+		}
+	}
+}
+
+func TestPriorityStarvation(t *testing.T) {
+	received := make(chan string, 64)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	})
+	ws := New(url)
+	ws.Config.MessageBufferSize = 32
+	ws.Connect()
+	defer ws.Close()
+
+	// 暂停后缓冲大量高优先级消息和一条普通消息，恢复后普通消息不会排到所有高优先级消息之后
+	ws.Pause()
+	if err := ws.SendTextMessage("normal"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := ws.SendTextMessagePriority("high", PriorityHigh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws.Resume()
+	for i := 0; i < 21; i++ {
+		select {
+		case got := <-received:
+			if got == "normal" {
+				if i != maxPriorityBurst {
+					t.Fatalf("normal message sent at position %d, expected %d", i, maxPriorityBurst)
+				}
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("messages not received")
+		}
+	}
+	t.Fatal("normal message not received")
+}
+
+func TestOnConnecting(t *testing.T) {
+	// 前两次握手失败，第三次成功
+	var handshakes int32
+	upgrader := websocket.Upgrader{}
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&handshakes, 1) <= 2 {
+			http.Error(w, "not yet", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	var attempts []int
+	ws.OnConnecting(func(u string, attempt int) {
+		if u != url {
+			t.Errorf("unexpected url %q", u)
+		}
+		attempts = append(attempts, attempt)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	if !reflect.DeepEqual(attempts, []int{1, 2, 3}) {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+}
+
+func TestManualCloseHandling(t *testing.T) {
+	received := make(chan string, 2)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		// 服务端已发送关闭帧，收到客户端关闭帧时无需回复
+		conn.SetCloseHandler(func(code int, text string) error { return nil })
+		closeMsg := websocket.FormatCloseMessage(4000, "maintenance")
+		if err := conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second)); err != nil {
+			return
+		}
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				if ce, ok := err.(*websocket.CloseError); ok {
+					received <- "close:" + strconv.Itoa(ce.Code)
+				}
+				return
+			}
+			received <- string(message)
+		}
+	})
+	ws := New(url)
+	ws.Config.ManualCloseHandling = true
+	closed := make(chan int, 2)
+	ws.OnClose(func(code int, text string) {
+		closed <- code
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	select {
+	case code := <-closed:
+		if code != 4000 {
+			t.Fatalf("expected close code 4000, got %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnClose not fired")
+	}
+	// 清理推迟到调用方关闭，期间仍可发送消息
+	if !ws.IsConnected() {
+		t.Fatal("connection cleaned before user closed it")
+	}
+	if err := ws.SendTextMessage("final"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ws.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"final", "close:1000"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("server did not receive %q", want)
+		}
+	}
+	if ws.IsConnected() {
+		t.Fatal("still connected after Shutdown")
+	}
+}
+
+func TestSendAcrossReconnects(t *testing.T) {
+	url := newTestServer(t, drainHandler)
+	ws := New(url)
+	ws.Config.MessageBufferSize = 16
+	connected := make(chan struct{}, 16)
+	ws.OnConnected(func() {
+		connected <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+	<-connected
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_ = ws.SendTextMessage("hello")
+				_ = ws.SendPing(nil)
+				runtime.Gosched()
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+		ws.Reconnect()
+		select {
+		case <-connected:
+		case <-time.After(time.Second):
+			t.Fatalf("reconnect %d did not complete", i+1)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestPingKeepsConnectionAlive(t *testing.T) {
+	const readTimeout = 300 * time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		// 服务端只发送ping，不发送任何数据
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+					return
+				}
+			}
+		}
+	})
+	ws := New(url)
+	ws.Config.ReadTimeout = readTimeout
+	ws.Config.KeepaliveTime = time.Hour
+	ws.Config.EnableReconnect = false
+	disconnected := make(chan error, 1)
+	ws.OnDisconnected(func(err error) {
+		disconnected <- err
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	select {
+	case err := <-disconnected:
+		t.Fatalf("disconnected while pings were arriving: %v", err)
+	case <-time.After(3 * readTimeout):
+	}
+}
+
+func TestOnMessageReceivedAt(t *testing.T) {
+	const n = 20
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for i := 0; i < n; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(i))); err != nil {
+				return
+			}
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte("binary")); err != nil {
+			return
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	var stamps []time.Time
+	var texts int32
+	ws.OnTextMessageReceived(func(message []byte) {
+		atomic.AddInt32(&texts, 1)
+	})
+	ws.OnTextMessageReceivedAt(func(message []byte, readAt time.Time) {
+		stamps = append(stamps, readAt)
+	})
+	binaryAt := make(chan time.Time, 1)
+	ws.OnBinaryMessageReceivedAt(func(data []byte, readAt time.Time) {
+		binaryAt <- readAt
+	})
+	start := time.Now()
+	ws.Connect()
+	defer ws.Close()
+
+	var last time.Time
+	select {
+	case last = <-binaryAt:
+	case <-time.After(time.Second):
+		t.Fatal("binary message not received")
+	}
+	if len(stamps) != n || atomic.LoadInt32(&texts) != n {
+		t.Fatalf("expected %d text messages, got %d stamps and %d callbacks", n, len(stamps), texts)
+	}
+	if stamps[0].Before(start) {
+		t.Fatal("timestamp before connect")
+	}
+	for i := 1; i < n; i++ {
+		if stamps[i].Before(stamps[i-1]) {
+			t.Fatalf("timestamp %d went backwards", i)
+		}
+	}
+	if last.Before(stamps[n-1]) {
+		t.Fatal("binary timestamp before last text timestamp")
+	}
+}
+
+func TestOnStateChange(t *testing.T) {
+	// 首次连接建立后立即断开，重连后保持
+	var handshakes int32
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		if atomic.AddInt32(&handshakes, 1) == 1 {
+			return
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	var mu sync.Mutex
+	var transitions []string
+	reconnected := make(chan struct{}, 1)
+	ws.OnStateChange(func(old, new State) {
+		mu.Lock()
+		transitions = append(transitions, old.String()+"->"+new.String())
+		mu.Unlock()
+		if old == Reconnecting && new == Connected {
+			reconnected <- struct{}{}
+		}
+	})
+	if ws.State() != Disconnected {
+		t.Fatalf("unexpected initial state %v", ws.State())
+	}
+	ws.Connect()
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("not reconnected")
+	}
+	ws.Close()
+	if ws.State() != Closed {
+		t.Fatalf("expected Closed, got %v", ws.State())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"Disconnected->Connecting",
+		"Connecting->Connected",
+		"Connected->Reconnecting",
+		"Reconnecting->Connected",
+		"Connected->Closing",
+		"Closing->Closed",
+	}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Fatalf("expected %v, got %v", want, transitions)
+	}
+}
+
+func TestSendRateLimit(t *testing.T) {
+	// 按比例缩短的100条/10每秒，20条消息以100条每秒发送约需190ms
+	const n = 20
+	received := make(chan time.Time, n)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			received <- time.Now()
+		}
+	})
+	ws := New(url)
+	ws.Config.MessageBufferSize = n
+	ws.Config.SendRateLimit = 100
+	ws.Config.KeepaliveTime = 20 * time.Millisecond
+	var keepalives int32
+	ws.OnKeepalive(func() {
+		atomic.AddInt32(&keepalives, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := ws.SendTextMessage(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var last time.Time
+	for i := 0; i < n; i++ {
+		select {
+		case last = <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d of %d messages", i, n)
+		}
+	}
+	if elapsed := last.Sub(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected delivery over about 190ms, took %v", elapsed)
+	}
+	// 限速等待期间心跳照常发送
+	if atomic.LoadInt32(&keepalives) < 3 {
+		t.Fatalf("keepalive blocked by rate limit, got %d", keepalives)
+	}
+}
+
+// idMessage 带id的请求和响应
+type idMessage struct {
+	ID   string `json:"id"`
+	Data string `json:"data"`
+}
+
+func TestRequest(t *testing.T) {
+	// 按收到请求的逆序回复，验证响应按id匹配
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		var batch []idMessage
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req idMessage
+			if err := json.Unmarshal(message, &req); err != nil {
+				return
+			}
+			batch = append(batch, req)
+			if len(batch) < 4 {
+				continue
+			}
+			for i := len(batch) - 1; i >= 0; i-- {
+				resp, _ := json.Marshal(idMessage{ID: batch[i].ID, Data: "re:" + batch[i].Data})
+				if err := conn.WriteMessage(websocket.TextMessage, resp); err != nil {
+					return
+				}
+			}
+			batch = batch[:0]
+		}
+	})
+	ws := New(url)
+	ws.Config.IDExtractor = func(message []byte) (string, bool) {
+		var msg idMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return "", false
+		}
+		return msg.ID, msg.ID != ""
+	}
+	var unmatched int32
+	ws.OnTextMessageReceived(func(message []byte) {
+		atomic.AddInt32(&unmatched, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := strconv.Itoa(i)
+			payload, _ := json.Marshal(idMessage{ID: id, Data: "payload" + id})
+			resp, err := ws.Request(ctx, id, payload)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var msg idMessage
+			if err := json.Unmarshal(resp, &msg); err != nil {
+				t.Error(err)
+				return
+			}
+			if msg.ID != id || msg.Data != "re:payload"+id {
+				t.Errorf("request %s resolved with %+v", id, msg)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&unmatched); n != 0 {
+		t.Fatalf("responses dispatched to OnTextMessageReceived: %d", n)
+	}
+
+	// 未收到响应时断开连接返回ErrClose
+	result := make(chan error, 1)
+	go func() {
+		_, err := ws.Request(context.Background(), "lonely", []byte(`{"id":"lonely"}`))
+		result <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	ws.Close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrClose) {
+			t.Fatalf("expected ErrClose, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request not released on close")
+	}
+}
+
+func TestUse(t *testing.T) {
+	// 服务端收到base64编码的消息，解码后加上前缀再编码回复
+	onWire := make(chan string, 1)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			onWire <- string(message)
+			data, err := base64.StdEncoding.DecodeString(string(message))
+			if err != nil {
+				return
+			}
+			reply := base64.StdEncoding.EncodeToString(append([]byte("echo:"), data...))
+			if err := conn.WriteMessage(messageType, []byte(reply)); err != nil {
+				return
+			}
+			// 回复一条无法解码的消息
+			if err := conn.WriteMessage(messageType, []byte("!")); err != nil {
+				return
+			}
+		}
+	})
+	ws := New(url)
+	ws.Use(func(data []byte) ([]byte, error) {
+		return []byte(base64.StdEncoding.EncodeToString(data)), nil
+	}, func(data []byte) ([]byte, error) {
+		return base64.StdEncoding.DecodeString(string(data))
+	})
+	received := make(chan string, 2)
+	ws.OnBinaryMessageReceived(func(data []byte) {
+		received <- string(data)
+	})
+	receiveErrs := make(chan error, 1)
+	ws.OnReceiveError(func(err error) {
+		receiveErrs <- err
+	})
+	sent := make(chan string, 1)
+	ws.OnBinaryMessageSent(func(data []byte) {
+		sent <- string(data)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	payload := []byte{0, 1, 2, 0xff, 'h', 'i'}
+	if err := ws.SendBinaryMessage(payload); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-onWire; got != base64.StdEncoding.EncodeToString(payload) {
+		t.Fatalf("outbound interceptor not applied, wire %q", got)
+	}
+	if got := <-sent; got != string(payload) {
+		t.Fatalf("OnBinaryMessageSent should see the original message, got %q", got)
+	}
+	select {
+	case got := <-received:
+		if got != "echo:"+string(payload) {
+			t.Fatalf("round trip mismatch: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply")
+	}
+	select {
+	case <-receiveErrs:
+	case <-time.After(time.Second):
+		t.Fatal("inbound interceptor error not reported")
+	}
+
+	// 出站拦截器失败时放弃发送并触发OnSentError
+	errIntercept := errors.New("intercept failed")
+	ws.Use(func(data []byte) ([]byte, error) {
+		return nil, errIntercept
+	}, nil)
+	sentErrs := make(chan error, 1)
+	ws.OnSentError(func(err error) {
+		sentErrs <- err
+	})
+	if err := ws.SendBinaryMessage(payload); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-sentErrs:
+		if err != errIntercept {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("outbound interceptor error not reported")
+	}
+	select {
+	case got := <-onWire:
+		t.Fatalf("message written despite interceptor error: %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCloseGracePeriod(t *testing.T) {
+	const replyDelay = 100 * time.Millisecond
+	result := make(chan error, 1)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		// 延迟回复关闭帧
+		conn.SetCloseHandler(func(code int, text string) error {
+			time.Sleep(replyDelay)
+			err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+			result <- err
+			return err
+		})
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.CloseGracePeriod = time.Second
+	var disconnected int32
+	ws.OnDisconnected(func(err error) {
+		atomic.AddInt32(&disconnected, 1)
+	})
+	closed := make(chan int, 1)
+	ws.OnClose(func(code int, text string) {
+		closed <- code
+	})
+	ws.Connect()
+
+	start := time.Now()
+	ws.Close()
+	elapsed := time.Since(start)
+	if elapsed < replyDelay || elapsed >= time.Second {
+		t.Fatalf("expected Close to wait for the close reply, took %v", elapsed)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("server could not reply close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not reply close")
+	}
+	if code := <-closed; code != websocket.CloseNormalClosure {
+		t.Fatalf("unexpected close code %d", code)
+	}
+	if n := atomic.LoadInt32(&disconnected); n != 0 {
+		t.Fatalf("OnDisconnected fired %d times for a client close", n)
+	}
+}
+
+func TestStats(t *testing.T) {
+	var handshakes int32
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		if atomic.AddInt32(&handshakes, 1) == 1 {
+			return
+		}
+		echoHandler(conn)
+	})
+	ws := New(url)
+	connected := make(chan struct{}, 2)
+	ws.OnConnected(func() {
+		connected <- struct{}{}
+	})
+	received := make(chan struct{}, 2)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+	<-connected
+	<-connected
+
+	for _, message := range []string{"hello", "world!"} {
+		if err := ws.SendTextMessage(message); err != nil {
+			t.Fatal(err)
+		}
+		<-received
+	}
+	want := Stats{MessagesSent: 2, MessagesReceived: 2, BytesSent: 11, BytesReceived: 11, Reconnects: 1}
+	if got := ws.Stats(); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestOnWriteTimeout(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	// 服务端不读取消息，写满TCP缓冲后客户端写入超时
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		<-stop
+	})
+	ws := New(url)
+	ws.Config.WriteWait = 100 * time.Millisecond
+	ws.Config.EnableReconnect = false
+	connected := make(chan struct{})
+	ws.OnConnected(func() {
+		close(connected)
+	})
+	var sentErr int32
+	ws.OnSentError(func(err error) {
+		atomic.StoreInt32(&sentErr, 1)
+	})
+	timedOut := make(chan []byte, 64)
+	ws.OnWriteTimeout(func(message []byte) {
+		timedOut <- message
+	})
+	ws.Connect()
+	defer ws.Close()
+	<-connected
+
+	// 先全部放入缓冲通道，避免写入超时断开后入队失败
+	ws.Pause()
+	payloads := make(map[string]bool)
+	for i := 0; i < 32; i++ {
+		payload := strings.Repeat(string(rune('a'+i)), 1<<20)
+		payloads[payload] = true
+		if err := ws.SendTextMessage(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws.Resume()
+	select {
+	case message := <-timedOut:
+		if !payloads[string(message)] {
+			t.Fatalf("unexpected payload of %d bytes", len(message))
+		}
+		if atomic.LoadInt32(&sentErr) != 1 {
+			t.Fatal("expected OnSentError before OnWriteTimeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write did not time out")
+	}
+}
+
+func TestPersistPending(t *testing.T) {
+	drop := make(chan struct{})
+	var handshakes int32
+	received := make(chan string, 8)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		if atomic.AddInt32(&handshakes, 1) == 1 {
+			<-drop
+			return
+		}
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	})
+	ws := New(url)
+	ws.Config.PersistPending = true
+	connected := make(chan struct{}, 2)
+	ws.OnConnected(func() {
+		connected <- struct{}{}
+	})
+	replayed := make(chan int, 1)
+	ws.OnReplay(func(count int) {
+		replayed <- count
+	})
+	ws.Connect()
+	defer ws.Close()
+	<-connected
+
+	// 暂停发送，让消息留在缓冲通道中，随后服务端断开连接
+	ws.Pause()
+	for _, message := range []string{"a", "b", "c"} {
+		if err := ws.SendTextMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(drop)
+	select {
+	case count := <-replayed:
+		if count != 3 {
+			t.Fatalf("expected 3 replayed messages, got %d", count)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("buffered messages were not replayed")
+	}
+	ws.Resume()
+	for _, want := range []string{"a", "b", "c"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %q not delivered after reconnect", want)
+		}
+	}
+}
+
+func TestSetReadLimit(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+			return
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, make([]byte, 64)); err != nil {
+			return
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.MaxMessageSize = 16
+	// 未连接时调整不会出错
+	ws.SetReadLimit(16)
+	ws.OnTextMessageReceived(func(message []byte) {
+		// 模拟握手消息协商出更大的帧长度
+		ws.SetReadLimit(128)
+	})
+	received := make(chan int, 1)
+	ws.OnBinaryMessageReceived(func(data []byte) {
+		received <- len(data)
+	})
+	tooBig := make(chan struct{}, 1)
+	ws.OnMessageTooBig(func(limit int64) {
+		tooBig <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	select {
+	case n := <-received:
+		if n != 64 {
+			t.Fatalf("expected 64 bytes, got %d", n)
+		}
+	case <-tooBig:
+		t.Fatal("raised read limit not applied")
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestOnConnectErrorTimed(t *testing.T) {
+	// 监听后立即关闭，连接会被拒绝
+	refused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedAddr := refused.Addr().String()
+	refused.Close()
+	// 接受连接但不响应握手
+	blackhole, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blackhole.Close()
+	go func() {
+		for {
+			conn, err := blackhole.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	dialElapsed := func(addr string) time.Duration {
+		ws := New("ws://" + addr)
+		ws.SetDialer(&websocket.Dialer{HandshakeTimeout: 200 * time.Millisecond})
+		var elapsed time.Duration
+		var attempts int
+		ws.OnConnectErrorTimed(func(err error, d time.Duration, attempt int) {
+			elapsed, attempts = d, attempt
+		})
+		ws.OnConnectErrorDecision(func(err error, attempt int) bool {
+			return false
+		})
+		if err := ws.ConnectContext(context.Background()); err == nil {
+			t.Fatalf("expected connect to %s to fail", addr)
+		}
+		if attempts != 1 {
+			t.Fatalf("expected attempt 1, got %d", attempts)
+		}
+		return elapsed
+	}
+	fast, slow := dialElapsed(refusedAddr), dialElapsed(blackhole.Addr().String())
+	if fast >= 100*time.Millisecond {
+		t.Fatalf("refused dial took %v", fast)
+	}
+	if slow < 200*time.Millisecond {
+		t.Fatalf("handshake timeout reported after %v", slow)
+	}
+}
+
+func TestAsyncCallbacks(t *testing.T) {
+	const total = 5
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for i := 0; i < total; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+				return
+			}
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.AsyncCallbacks = true
+	release := make(chan struct{})
+	started := make(chan struct{}, total)
+	ws.OnTextMessageReceived(func(message []byte) {
+		started <- struct{}{}
+		// 回调在读协程中执行时会阻塞后续消息的读取
+		<-release
+		// 回调中发送消息不会死锁
+		_ = ws.SendTextMessage("ack")
+	})
+	ws.Connect()
+	defer ws.Close()
+	defer close(release)
+
+	for i := 0; i < total; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("read loop blocked by slow callback after %d messages", i)
+		}
+	}
+}
+
+func TestCallbackQueueSize(t *testing.T) {
+	const total = 20
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for i := 0; i < total; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(i))); err != nil {
+				return
+			}
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.CallbackQueueSize = total
+	// 同时开启时按序执行优先
+	ws.Config.AsyncCallbacks = true
+	received := make(chan string, total)
+	ws.OnTextMessageReceived(func(message []byte) {
+		time.Sleep(5 * time.Millisecond)
+		received <- string(message)
+	})
+	ws.OnCallbackOverflow(func(messageType int, data []byte) {
+		t.Errorf("unexpected overflow for %q", data)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	for i := 0; i < total; i++ {
+		select {
+		case got := <-received:
+			if got != strconv.Itoa(i) {
+				t.Fatalf("expected message %d, got %s", i, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d not delivered", i)
+		}
+	}
+}
+
+func TestCallbackOverflow(t *testing.T) {
+	next := make(chan struct{})
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for i := 0; i < 3; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(i))); err != nil {
+				return
+			}
+			// 等第一条消息进入回调后再发送后续消息
+			if i == 0 {
+				<-next
+			}
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.CallbackQueueSize = 1
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	overflow := make(chan string, 3)
+	ws.OnCallbackOverflow(func(messageType int, data []byte) {
+		overflow <- string(data)
+	})
+	ws.Connect()
+	defer ws.Close()
+	defer close(release)
+
+	// 第一条消息阻塞在回调中，第二条占满队列，第三条被丢弃
+	<-started
+	close(next)
+	select {
+	case got := <-overflow:
+		if got != "2" {
+			t.Fatalf("expected message 2 to overflow, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnCallbackOverflow not fired")
+	}
+}
+
+func TestDisableKeepalive(t *testing.T) {
+	var pings int32
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		defaultPingHandler := conn.PingHandler()
+		conn.SetPingHandler(func(appData string) error {
+			atomic.AddInt32(&pings, 1)
+			return defaultPingHandler(appData)
+		})
+		echoHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.KeepaliveTime = 20 * time.Millisecond
+	ws.Config.DisableKeepalive = true
+	received := make(chan string, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- string(message)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&pings); n != 0 {
+		t.Fatalf("expected no keepalive pings, got %d", n)
+	}
+	// 手动保活和收发消息不受影响
+	if err := ws.SendPing(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != "hello" {
+			t.Fatalf("unexpected echo %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection unusable with keepalive disabled")
+	}
+	if n := atomic.LoadInt32(&pings); n != 1 {
+		t.Fatalf("expected 1 manual ping, got %d", n)
+	}
+}
+
+func TestLocalRemoteAddr(t *testing.T) {
+	url := newTestServer(t, drainHandler)
+	ws := New(url)
+	if ws.LocalAddr() != nil || ws.RemoteAddr() != nil {
+		t.Fatal("expected nil addresses before connecting")
+	}
+	ws.Connect()
+
+	local, remote := ws.LocalAddr(), ws.RemoteAddr()
+	if local == nil || remote == nil {
+		t.Fatalf("expected addresses while connected, got %v %v", local, remote)
+	}
+	if want := strings.TrimPrefix(url, "ws://"); remote.String() != want {
+		t.Fatalf("expected remote %s, got %s", want, remote)
+	}
+	ws.Close()
+	if ws.LocalAddr() != nil || ws.RemoteAddr() != nil {
+		t.Fatal("expected nil addresses after close")
+	}
+}
+
+func TestOriginAndHeaders(t *testing.T) {
+	var handshakes int32
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") == "https://example.com"
+		},
+	}
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&handshakes, 1)
+		drainHandler(conn)
+	})
+
+	// 没有Origin时握手被拒绝
+	ws := New(url)
+	ws.Config.Headers = map[string]string{"X-Token": "secret"}
+	ws.OnConnectErrorDecision(func(err error, attempt int) bool {
+		return false
+	})
+	var handshakeErr *HandshakeError
+	if err := ws.ConnectContext(context.Background()); !errors.As(err, &handshakeErr) || handshakeErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected forbidden handshake, got %v", err)
+	}
+
+	ws = New(url)
+	ws.Config.Origin = "https://example.com"
+	ws.Config.Headers = map[string]string{"X-Token": "secret"}
+	connected := make(chan struct{}, 2)
+	ws.OnConnected(func() {
+		connected <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+	// 重连时同样携带
+	ws.Reconnect()
+	select {
+	case <-connected:
+		<-connected
+	case <-time.After(time.Second):
+		t.Fatal("reconnect with Origin failed")
+	}
+	if n := atomic.LoadInt32(&handshakes); n != 2 {
+		t.Fatalf("expected 2 accepted handshakes, got %d", n)
+	}
+	if ws.WebSocket.RequestHeader.Get("Origin") != "" {
+		t.Fatal("RequestHeader should not be modified")
+	}
+}
+
+func TestSetURL(t *testing.T) {
+	fromA, fromB := make(chan string, 1), make(chan string, 1)
+	serverA := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			fromA <- string(message)
+		}
+	})
+	serverB := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			fromB <- string(message)
+		}
+	})
+	ws := New(serverA)
+	connected := make(chan struct{}, 2)
+	ws.OnConnected(func() {
+		connected <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+	<-connected
+	if err := ws.SendTextMessage("a"); err != nil {
+		t.Fatal(err)
+	}
+	<-fromA
+
+	ws.SetURL(serverB)
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("did not reconnect after SetURL")
+	}
+	if got := ws.ConnectedURL(); got != serverB {
+		t.Fatalf("expected connected to %s, got %s", serverB, got)
+	}
+	if err := ws.SendTextMessage("b"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-fromB:
+		if got != "b" {
+			t.Fatalf("unexpected message %q", got)
+		}
+	case got := <-fromA:
+		t.Fatalf("message %q sent to old server", got)
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered to new server")
+	}
+}
+
+func TestMaxConcurrentCallbacks(t *testing.T) {
+	const total, limit = 20, 3
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for i := 0; i < total; i++ {
+			if err := conn.WriteMessage(websocket.BinaryMessage, []byte{byte(i)}); err != nil {
+				return
+			}
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.AsyncCallbacks = true
+	ws.Config.MaxConcurrentCallbacks = limit
+	var running, peak int32
+	var wg sync.WaitGroup
+	wg.Add(total)
+	ws.OnBinaryMessageReceived(func(data []byte) {
+		defer wg.Done()
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("callbacks did not complete")
+	}
+	if p := atomic.LoadInt32(&peak); p > limit {
+		t.Fatalf("expected at most %d concurrent callbacks, got %d", limit, p)
+	} else if p < 2 {
+		t.Fatalf("expected callbacks to run concurrently, peak %d", p)
+	}
+}
+
+func TestDuplicateConnect(t *testing.T) {
+	var handshakes int32
+	upgrader := websocket.Upgrader{}
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&handshakes, 1)
+		// 放慢握手，让第二次Connect发生在连接过程中
+		time.Sleep(50 * time.Millisecond)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		drainHandler(conn)
+	})
+	ws := New(url)
+	var connected int32
+	ws.OnConnected(func() {
+		atomic.AddInt32(&connected, 1)
+	})
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- ws.ConnectContext(context.Background())
+		}()
+	}
+	var succeeded int
+	for i := 0; i < 2; i++ {
+		switch err := <-errs; err {
+		case nil:
+			succeeded++
+		case ErrAlreadyConnecting, ErrAlreadyConnected:
+		default:
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	defer ws.Close()
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one successful connect, got %d", succeeded)
+	}
+	if err := ws.ConnectContext(context.Background()); err != ErrAlreadyConnected {
+		t.Fatalf("expected ErrAlreadyConnected, got %v", err)
+	}
+	ws.Connect()
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&handshakes); n != 1 {
+		t.Fatalf("expected 1 handshake, got %d", n)
+	}
+	if n := atomic.LoadInt32(&connected); n != 1 {
+		t.Fatalf("expected 1 OnConnected, got %d", n)
+	}
+}
+
+func TestSendTextMessages(t *testing.T) {
+	url := newTestServer(t, drainHandler)
+	ws := New(url)
+	ws.Config.MessageBufferSize = 3
+	ws.Connect()
+
+	// 暂停发送，缓冲通道中已有一条消息，批量发送时第三条起缓冲已满
+	ws.Pause()
+	if err := ws.SendTextMessage("first"); err != nil {
+		t.Fatal(err)
+	}
+	errs := ws.SendTextMessages([]string{"a", "b", "c", "d"})
+	want := []error{nil, nil, ErrBuffer, ErrBuffer}
+	if !reflect.DeepEqual(errs, want) {
+		t.Fatalf("expected %v, got %v", want, errs)
+	}
+
+	ws.Close()
+	errs = ws.SendTextMessages([]string{"a", "b"})
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d", len(errs))
+	}
+	for i, err := range errs {
+		if !errors.Is(err, ErrClose) {
+			t.Fatalf("expected ErrClose for message %d, got %v", i, err)
+		}
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	ws := New(url)
+	ws.Config.MaxIdleTime = 100 * time.Millisecond
+	received := make(chan struct{}, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- struct{}{}
+	})
+	if ws.Healthy() {
+		t.Fatal("healthy before connecting")
+	}
+	ws.Connect()
+	defer ws.Close()
+	if !ws.Healthy() {
+		t.Fatal("not healthy right after connecting")
+	}
+
+	// 没有任何流量，超过空闲时间后不再健康，但连接仍在
+	time.Sleep(150 * time.Millisecond)
+	if ws.Healthy() {
+		t.Fatal("healthy after idle window")
+	}
+	if !ws.IsConnected() {
+		t.Fatal("idle connection should stay connected")
+	}
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+	if !ws.Healthy() {
+		t.Fatal("not healthy after receiving a message")
+	}
+}
+
+func TestTLSConnectionState(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		drainHandler(conn)
+	}))
+	defer srv.Close()
+
+	ws := New("wss" + strings.TrimPrefix(srv.URL, "https"))
+	ws.SetDialer(&websocket.Dialer{
+		TLSClientConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig,
+	})
+	if _, ok := ws.TLSConnectionState(); ok {
+		t.Fatal("expected no TLS state before connecting")
+	}
+	ws.Connect()
+	defer ws.Close()
+	state, ok := ws.TLSConnectionState()
+	if !ok {
+		t.Fatal("expected TLS state over wss")
+	}
+	if state.Version < tls.VersionTLS12 {
+		t.Fatalf("expected at least TLS 1.2, got %x", state.Version)
+	}
+	if !state.HandshakeComplete {
+		t.Fatal("handshake not complete")
+	}
+
+	// 明文连接没有TLS状态
+	plain := New(newTestServer(t, drainHandler))
+	plain.Connect()
+	defer plain.Close()
+	if _, ok := plain.TLSConnectionState(); ok {
+		t.Fatal("expected no TLS state over ws")
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	ws := New(url)
+	received := make(chan string, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- string(message)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, _, err := ws.ReadMessage(ctx); !errors.Is(err, ErrClose) {
+		t.Fatalf("expected ErrClose before connecting, got %v", err)
+	}
+	ws.Connect()
+	defer ws.Close()
+
+	// 等待期间收到的消息交给ReadMessage，不触发回调
+	result := make(chan string, 1)
+	go func() {
+		messageType, data, err := ws.ReadMessage(ctx)
+		if err != nil || messageType != websocket.TextMessage {
+			result <- fmt.Sprintf("error %v type %d", err, messageType)
+			return
+		}
+		result <- string(data)
+	}()
+	for {
+		ws.readersMu.Lock()
+		n := len(ws.readers)
+		ws.readersMu.Unlock()
+		if n == 1 {
+			break
+		}
+		runtime.Gosched()
+	}
+	if err := ws.SendTextMessage("sync"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-result; got != "sync" {
+		t.Fatalf("unexpected ReadMessage result %s", got)
+	}
+
+	// 之后的消息恢复由回调处理
+	if err := ws.SendTextMessage("async"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != "async" {
+			t.Fatalf("unexpected callback message %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback not resumed after ReadMessage")
+	}
+	select {
+	case got := <-received:
+		t.Fatalf("message %q delivered to both ReadMessage and callback", got)
+	default:
+	}
+
+	// ctx结束时返回ctx的错误
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	if _, _, err := ws.ReadMessage(short); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+// blockingCloseConn Close阻塞直到release关闭的连接，模拟缓慢的TCP断开
+type blockingCloseConn struct {
+	net.Conn
+	release chan struct{}
+}
+
+func (c *blockingCloseConn) Close() error {
+	<-c.release
+	return c.Conn.Close()
+}
+
+func TestCloseTimeout(t *testing.T) {
+	url := newTestServer(t, drainHandler)
+	release := make(chan struct{})
+	defer close(release)
+	ws := New(url)
+	ws.Config.CloseTimeout = 50 * time.Millisecond
+	ws.Config.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &blockingCloseConn{Conn: conn, release: release}, nil
+	}
+	ws.Connect()
+
+	start := time.Now()
+	ws.Close()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Close blocked for %v", elapsed)
+	}
+	// 关闭期间不持有锁
+	if ws.IsConnected() || ws.State() != Closed {
+		t.Fatalf("unexpected state %v after close", ws.State())
+	}
+}
+
+func TestConnectWithTimeout(t *testing.T) {
+	// 预留端口，服务端稍后才在该端口启动
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ws := New("ws://" + addr)
+	ws.Config.MinRecTime = 20 * time.Millisecond
+	ws.Config.MaxRecTime = 50 * time.Millisecond
+	err = ws.ConnectWithTimeout(100 * time.Millisecond)
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("expected last dial error, got %v", err)
+	}
+	if ws.State() != Disconnected {
+		t.Fatalf("expected Disconnected after timeout, got %v", ws.State())
+	}
+
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		drainHandler(conn)
+	}))
+	started := make(chan struct{})
+	defer func() {
+		<-started
+		srv.Close()
+	}()
+	go func() {
+		defer close(started)
+		time.Sleep(200 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		srv.Listener = l
+		srv.Start()
+	}()
+	if err := ws.ConnectWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if !ws.IsConnected() {
+		t.Fatal("not connected")
+	}
+}
+
+func TestSendPrepared(t *testing.T) {
+	type frame struct {
+		messageType int
+		data        string
+	}
+	received := make(chan frame, 2)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- frame{messageType, string(message)}
+		}
+	})
+	pm, err := websocket.NewPreparedMessage(websocket.TextMessage, []byte("broadcast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同一消息发送给多个客户端
+	for i := 0; i < 2; i++ {
+		ws := New(url)
+		ws.Connect()
+		defer ws.Close()
+		if err := ws.SendPrepared(pm); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-received:
+			if got.messageType != websocket.TextMessage || got.data != "broadcast" {
+				t.Fatalf("unexpected frame %d %q", got.messageType, got.data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("prepared message not received")
+		}
+	}
+}
+
+func TestHeartbeatMessage(t *testing.T) {
+	type frame struct {
+		messageType int
+		data        string
+		at          time.Time
+	}
+	frames := make(chan frame, 8)
+	var pings int32
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		defaultPingHandler := conn.PingHandler()
+		conn.SetPingHandler(func(appData string) error {
+			atomic.AddInt32(&pings, 1)
+			return defaultPingHandler(appData)
+		})
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			frames <- frame{messageType, string(message), time.Now()}
+		}
+	})
+	ws := New(url)
+	ws.Config.KeepaliveTime = 50 * time.Millisecond
+	ws.Config.HeartbeatMessage = []byte(`{"op":"ping"}`)
+	var keepalives int32
+	ws.OnKeepalive(func() {
+		atomic.AddInt32(&keepalives, 1)
+	})
+	var sent int32
+	ws.OnTextMessageSent(func(message []byte) {
+		atomic.AddInt32(&sent, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	var last time.Time
+	for i := 0; i < 3; i++ {
+		select {
+		case got := <-frames:
+			if got.messageType != websocket.TextMessage || got.data != `{"op":"ping"}` {
+				t.Fatalf("unexpected heartbeat %d %q", got.messageType, got.data)
+			}
+			if !last.IsZero() && got.at.Sub(last) < 30*time.Millisecond {
+				t.Fatalf("heartbeats only %v apart", got.at.Sub(last))
+			}
+			last = got.at
+		case <-time.After(time.Second):
+			t.Fatal("heartbeat not received")
+		}
+	}
+	if n := atomic.LoadInt32(&pings); n != 0 {
+		t.Fatalf("expected no ping frames, got %d", n)
+	}
+	// 最后一次心跳的回调可能还未执行
+	if n := atomic.LoadInt32(&keepalives); n < 2 {
+		t.Fatalf("expected OnKeepalive for each heartbeat, got %d", n)
+	}
+	if n := atomic.LoadInt32(&sent); n != 0 {
+		t.Fatalf("heartbeats should not fire OnTextMessageSent, got %d", n)
+	}
+}
+
+func TestHeartbeatMatcher(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		ticker := time.NewTicker(30 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"op":"pong"}`)); err != nil {
+					return
+				}
+			}
+		}
+	})
+	ws := New(url)
+	ws.Config.MaxIdleTime = 100 * time.Millisecond
+	ws.Config.HeartbeatMatcher = func(message []byte) bool {
+		return bytes.Equal(message, []byte(`{"op":"pong"}`))
+	}
+	var heartbeats int32
+	ws.OnServerHeartbeat(func() {
+		atomic.AddInt32(&heartbeats, 1)
+	})
+	ws.OnTextMessageReceived(func(message []byte) {
+		t.Errorf("heartbeat %q reached OnTextMessageReceived", message)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if !ws.Healthy() {
+			t.Fatal("not healthy while heartbeats arrive")
+		}
+	}
+	if n := atomic.LoadInt32(&heartbeats); n < 3 {
+		t.Fatalf("expected heartbeats, got %d", n)
+	}
+}
+
+func TestAsyncControlCallbacks(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		if err := conn.WriteControl(websocket.PongMessage, []byte("pong"), time.Now().Add(time.Second)); err != nil {
+			return
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+			return
+		}
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.AsyncControlCallbacks = true
+	release := make(chan struct{})
+	defer close(release)
+	pong := make(chan time.Time, 1)
+	ws.OnPongReceived(func(appData string) {
+		pong <- time.Now()
+		// 同步执行时会阻塞后续消息的读取
+		<-release
+	})
+	received := make(chan struct{}, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	<-pong
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("slow pong callback delayed message reads")
+	}
+}
+
+func TestAwaitReconnect(t *testing.T) {
+	srv := make(chan *websocket.Conn, 2)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		srv <- conn
+		drainHandler(conn)
+	})
+	ws := New(url)
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	var connected int32
+	ws.OnConnected(func() {
+		atomic.AddInt32(&connected, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+	conn := <-srv
+
+	// 已连接时等待的是断开后的下一次连接
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ws.AwaitReconnect(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected to wait for the next reconnect, got %v", err)
+	}
+
+	// 注册等待后服务端断开连接
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.Close()
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ws.AwaitReconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !ws.IsConnected() {
+		t.Fatal("not connected after AwaitReconnect")
+	}
+	if n := atomic.LoadInt32(&connected); n != 2 {
+		t.Fatalf("expected OnConnected twice, got %d", n)
+	}
+}
+
+func TestOnCloseDetailed(t *testing.T) {
+	type closed struct {
+		code     int
+		text     string
+		byClient bool
+	}
+	newClient := func(url string) (*Wsc, chan closed) {
+		ws := New(url)
+		closes := make(chan closed, 2)
+		ws.OnCloseDetailed(func(code int, text string, byClient bool) {
+			closes <- closed{code, text, byClient}
+		})
+		ws.Connect()
+		return ws, closes
+	}
+
+	// 服务端发起关闭
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye"), time.Now().Add(time.Second))
+		drainHandler(conn)
+	})
+	ws, closes := newClient(url)
+	select {
+	case got := <-closes:
+		if got != (closed{websocket.CloseGoingAway, "bye", false}) {
+			t.Fatalf("unexpected server close %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server close not reported")
+	}
+	ws.Close()
+
+	// 客户端主动关闭
+	ws, closes = newClient(newTestServer(t, drainHandler))
+	ws.CloseWithMsg("done")
+	select {
+	case got := <-closes:
+		if got != (closed{websocket.CloseNormalClosure, "done", true}) {
+			t.Fatalf("unexpected client close %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client close not reported")
+	}
+}
+
+func TestSendDuringClose(t *testing.T) {
+	url := newTestServer(t, drainHandler)
+	for i := 0; i < 20; i++ {
+		ws := New(url)
+		ws.Config.EnableReconnect = false
+		ws.Config.MessageBufferSize = 4
+		ws.Connect()
+
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 100; k++ {
+					var err error
+					if k%2 == 0 {
+						err = ws.SendTextMessage("hello")
+					} else {
+						err = ws.SendBinaryMessageBlocking(context.Background(), []byte("hello"))
+					}
+					if err != nil && !errors.Is(err, ErrClose) && err != ErrBuffer {
+						t.Errorf("unexpected send error %v", err)
+						return
+					}
+				}
+			}()
+		}
+		// 与发送并发断开连接
+		ws.Close()
+		wg.Wait()
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	const readTimeout = 150 * time.Millisecond
+	tests := []struct {
+		name string
+		// 服务端定时发送的数据，nil表示只回复客户端的ping
+		send func(conn *websocket.Conn) error
+		// 客户端心跳间隔
+		keepalive time.Duration
+	}{
+		{
+			name: "server ping",
+			send: func(conn *websocket.Conn) error {
+				return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+			},
+			keepalive: time.Hour,
+		},
+		{
+			name:      "pong",
+			keepalive: 50 * time.Millisecond,
+		},
+		{
+			name: "data",
+			send: func(conn *websocket.Conn) error {
+				return conn.WriteMessage(websocket.TextMessage, []byte("tick"))
+			},
+			keepalive: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			stop := make(chan struct{})
+			defer close(stop)
+			url := newTestServer(t, func(conn *websocket.Conn) {
+				if tt.send == nil {
+					drainHandler(conn)
+					return
+				}
+				ticker := time.NewTicker(50 * time.Millisecond)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-stop:
+						return
+					case <-ticker.C:
+						if err := tt.send(conn); err != nil {
+							return
+						}
+					}
+				}
+			})
+			ws := New(url)
+			ws.Config.ReadTimeout = readTimeout
+			ws.Config.MaxIdleTime = readTimeout
+			ws.Config.KeepaliveTime = tt.keepalive
+			ws.Config.EnableReconnect = false
+			disconnected := make(chan error, 1)
+			ws.OnDisconnected(func(err error) {
+				disconnected <- err
+			})
+			ws.Connect()
+			defer ws.Close()
+
+			select {
+			case err := <-disconnected:
+				t.Fatalf("read deadline not refreshed: %v", err)
+			case <-time.After(3 * readTimeout):
+			}
+			if !ws.Healthy() {
+				t.Fatal("liveness timestamp not refreshed")
+			}
+		})
+	}
+}
+
+func TestCloseGraceful(t *testing.T) {
+	// 服务端回复关闭帧，正常完成关闭握手
+	ws := New(newTestServer(t, drainHandler))
+	ws.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ws.CloseGraceful(ctx); err != nil {
+		t.Fatalf("expected clean close, got %v", err)
+	}
+	if ws.State() != Closed {
+		t.Fatalf("expected Closed, got %v", ws.State())
+	}
+
+	// 服务端不回复关闭帧，到期后强制断开
+	dropped := make(chan struct{})
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		conn.SetCloseHandler(func(code int, text string) error { return nil })
+		drainHandler(conn)
+		// 收到关闭帧后不回复也不断开，直到客户端断开底层连接
+		_, _ = io.Copy(io.Discard, conn.UnderlyingConn())
+		close(dropped)
+	})
+	ws = New(url)
+	ws.Connect()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ws.CloseGraceful(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Fatalf("force drop after %v", elapsed)
+	}
+	if ws.IsConnected() || ws.State() != Closed {
+		t.Fatalf("unexpected state %v after force drop", ws.State())
+	}
+	select {
+	case <-dropped:
+	case <-time.After(time.Second):
+		t.Fatal("socket not dropped")
+	}
+}
+
+func TestOnBackoff(t *testing.T) {
+	// 监听后立即关闭，连接会被拒绝
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ws := New("ws://" + addr)
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	ws.Config.MaxRecTime = 40 * time.Millisecond
+	ws.Config.RecFactor = 2
+	ws.Config.ReconnectJitter = false
+	var attempts []int
+	var delays []time.Duration
+	ws.OnBackoff(func(attempt int, delay time.Duration, err error) {
+		if err == nil {
+			t.Error("expected the dial error")
+		}
+		attempts = append(attempts, attempt)
+		delays = append(delays, delay)
+	})
+	ws.OnConnectErrorDecision(func(err error, attempt int) bool {
+		return attempt < 5
+	})
+	if err := ws.ConnectContext(context.Background()); err == nil {
+		t.Fatal("expected connect to fail")
+	}
+
+	// 第5次失败时停止重连，不再计算等待时间
+	if !reflect.DeepEqual(attempts, []int{1, 2, 3, 4}) {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond}
+	if !reflect.DeepEqual(delays, want) {
+		t.Fatalf("expected delays %v, got %v", want, delays)
+	}
+}
+
+// newRSV1Server 启动开启压缩的测试服务端，读取客户端原始帧的RSV1位，为true表示消息经过压缩，
+// 客户端帧带4字节掩码，测试消息长度需小于126
+func newRSV1Server(t *testing.T) (string, chan bool) {
+	t.Helper()
+	rsv1 := make(chan bool, 2)
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	url := newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		raw := conn.UnderlyingConn()
+		for {
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(raw, header); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(raw, make([]byte, 4+int(header[1]&0x7f))); err != nil {
+				return
+			}
+			rsv1 <- header[0]&0x40 != 0
+		}
+	})
+	return url, rsv1
+}
+
+func TestSendMessageCompression(t *testing.T) {
+	url, rsv1 := newRSV1Server(t)
+	ws := New(url)
+	ws.Config.CompressionMinSize = -1
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = true
+	ws.SetDialer(&dialer)
+	ws.Connect()
+	defer ws.Close()
+
+	data := []byte(strings.Repeat("compressible ", 8))
+	for _, compress := range []bool{true, false} {
+		if err := ws.SendMessageCompression(websocket.BinaryMessage, data, compress); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-rsv1:
+			if got != compress {
+				t.Fatalf("compress %v: expected RSV1 %v, got %v", compress, compress, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("frame not received")
+		}
+	}
+}
+
+func TestConfigFieldAssignment(t *testing.T) {
+	ws := New("ws://127.0.0.1:1")
+	ws.SetWriteWait(time.Second)
+	if ws.cfg().WriteWait != time.Second {
+		t.Fatalf("expected WriteWait 1s, got %v", ws.cfg().WriteWait)
+	}
+
+	// 方法修改后直接对Config字段整体赋值，以新赋值的配置为准
+	config := *defaultConfig()
+	config.WriteWait = 3 * time.Second
+	ws.Config = &config
+	if ws.cfg() != &config {
+		t.Fatal("assigned Config not adopted")
+	}
+	// 之后的修改基于新赋值的配置
+	if err := ws.SetKeepaliveInterval(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got := ws.cfg(); got.WriteWait != 3*time.Second || got.KeepaliveTime != time.Minute {
+		t.Fatalf("unexpected config WriteWait=%v KeepaliveTime=%v", got.WriteWait, got.KeepaliveTime)
+	}
+	if config.KeepaliveTime == time.Minute {
+		t.Fatal("assigned Config should not be modified")
+	}
+}
+
+func TestSetConfigValidation(t *testing.T) {
+	ws := New(newTestServer(t, echoHandler))
+	ws.Config.IdleKeepalive = true
+	ws.Config.KeepaliveTime = 10 * time.Millisecond
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SetKeepaliveInterval(0); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if err := ws.SetConfig(&Config{}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if err := ws.SetConfig(nil); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig for nil, got %v", err)
+	}
+	if got := ws.cfg().KeepaliveTime; got != 10*time.Millisecond {
+		t.Fatalf("invalid config applied, KeepaliveTime %v", got)
+	}
+	// 写协程没有因为不合法的心跳间隔退出
+	received := make(chan struct{}, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- struct{}{}
+	})
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("no echo after rejected config")
+	}
+	// 空闲心跳按原间隔继续
+	time.Sleep(50 * time.Millisecond)
+	if !ws.IsConnected() {
+		t.Fatal("connection lost after rejected config")
+	}
+}
+
+func TestSetConfigWhileConnected(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	ws := New(url)
+	ws.Config.KeepaliveTime = time.Hour
+	var keepalives int32
+	ws.OnKeepalive(func() {
+		atomic.AddInt32(&keepalives, 1)
+	})
+	var received int32
+	ws.OnTextMessageReceived(func(message []byte) {
+		atomic.AddInt32(&received, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	// 收发消息的同时修改配置
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = ws.SendTextMessage("hello")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		ws.SetWriteWait(time.Duration(i+1) * time.Second)
+		config := *ws.cfg()
+		config.WriteBatchWindow = time.Duration(i%2) * time.Millisecond
+		if err := ws.SetConfig(&config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	// 新的心跳间隔立即生效，不需要等待原定时器到期
+	if err := ws.SetKeepaliveInterval(20 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&keepalives); n < 2 {
+		t.Fatalf("expected keepalives after SetKeepaliveInterval, got %d", n)
+	}
+	if ws.cfg().WriteWait != 100*time.Second {
+		t.Fatalf("expected WriteWait 100s, got %v", ws.cfg().WriteWait)
+	}
+	if n := atomic.LoadInt32(&received); n == 0 {
+		t.Fatal("no messages echoed")
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	// 等待之前测试遗留的协程退出后再记录基线
+	time.Sleep(100 * time.Millisecond)
+	baseline := runtime.NumGoroutine()
+
+	ws := New(url)
+	ws.Config.CallbackQueueSize = 8
+	received := make(chan struct{}, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- struct{}{}
+	})
+	if err := ws.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("message not echoed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ws.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ws.IsConnected() {
+		t.Fatal("still connected after Stop")
+	}
+	// 服务端的连接协程在收到关闭帧后异步退出
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Fatalf("expected at most %d goroutines after Stop, got %d", baseline, n)
+	}
+}
+
+func TestCloseStopsReconnectLoop(t *testing.T) {
+	// 监听后立即关闭，连接会被拒绝
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	time.Sleep(100 * time.Millisecond)
+	baseline := runtime.NumGoroutine()
+
+	ws := New("ws://" + addr)
+	ws.Config.MinRecTime = 10 * time.Millisecond
+	ws.Config.MaxRecTime = 10 * time.Millisecond
+	exited := make(chan error, 1)
+	go func() {
+		exited <- ws.ConnectContext(context.Background())
+	}()
+	deadline := time.Now().Add(time.Second)
+	for ws.ReconnectAttempts() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ws.ReconnectAttempts() < 2 {
+		t.Fatal("client is not retrying")
+	}
+
+	ws.Close()
+	select {
+	case err := <-exited:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reconnect loop still running after Close")
+	}
+	deadline = time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Fatalf("expected at most %d goroutines after Close, got %d", baseline, n)
+	}
+	if ws.State() != Disconnected {
+		t.Fatalf("expected Disconnected, got %v", ws.State())
+	}
+}
+
+func TestCompressionMinSize(t *testing.T) {
+	url, rsv1 := newRSV1Server(t)
+	ws := New(url)
+	ws.Config.CompressionMinSize = 64
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = true
+	ws.SetDialer(&dialer)
+	ws.Connect()
+	defer ws.Close()
+
+	for _, tt := range []struct {
+		size       int
+		compressed bool
+	}{
+		{size: 16, compressed: false},
+		{size: 63, compressed: false},
+		{size: 64, compressed: true},
+		{size: 120, compressed: true},
+	} {
+		if err := ws.SendTextMessage(strings.Repeat("a", tt.size)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-rsv1:
+			if got != tt.compressed {
+				t.Fatalf("size %d: expected compressed %v, got %v", tt.size, tt.compressed, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("frame not received")
+		}
+	}
+}
+
+func TestSequenceTags(t *testing.T) {
+	dedup := NewDeduplicator(16)
+	applied := make(chan string, 8)
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			// 模拟至少一次投递，每条消息都被处理两次
+			for i := 0; i < 2; i++ {
+				if payload, ok := dedup.Accept(message); ok {
+					applied <- string(payload)
+				}
+			}
+		}
+	})
+	ws := New(url)
+	ws.Config.SequenceTags = true
+	ws.Connect()
+	defer ws.Close()
+
+	for _, message := range []string{"a", "b", "a"} {
+		if err := ws.SendTextMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 内容相同但序列号不同的消息依然处理，重复投递的消息只处理一次
+	for _, want := range []string{"a", "b", "a"} {
+		select {
+		case got := <-applied:
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message not applied")
+		}
+	}
+	select {
+	case got := <-applied:
+		t.Fatalf("duplicate applied: %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDeduplicatorWindow(t *testing.T) {
+	dedup := NewDeduplicator(2)
+	for _, tt := range []struct {
+		seq    uint64
+		accept bool
+	}{
+		{seq: 1, accept: true},
+		{seq: 2, accept: true},
+		{seq: 1, accept: false},
+		{seq: 3, accept: true},
+		// 超出窗口后忘记最早的序列号
+		{seq: 1, accept: true},
+		{seq: 3, accept: false},
+	} {
+		payload, ok := dedup.Accept(TagSequence(tt.seq, []byte("data")))
+		if ok != tt.accept {
+			t.Fatalf("seq %d: expected accept %v, got %v", tt.seq, tt.accept, ok)
+		}
+		if string(payload) != "data" {
+			t.Fatalf("seq %d: expected payload stripped, got %q", tt.seq, payload)
+		}
+	}
+	if payload, ok := dedup.Accept([]byte("untagged")); !ok || string(payload) != "untagged" {
+		t.Fatalf("expected untagged message passed through, got %q %v", payload, ok)
+	}
+}
+
+func TestOnRawFrame(t *testing.T) {
+	url := newTestServer(t, func(conn *websocket.Conn) {
+		deadline := time.Now().Add(time.Second)
+		_ = conn.WriteControl(websocket.PingMessage, []byte("ping"), deadline)
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("text"))
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "bye"), deadline)
+		_, _, _ = conn.ReadMessage()
+	})
+	ws := New(url)
+	ws.Config.EnableReconnect = false
+	type frame struct {
+		messageType int
+		data        string
+	}
+	frames := make(chan frame, 3)
+	ws.OnRawFrame(func(messageType int, data []byte) {
+		frames <- frame{messageType, string(data)}
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	for _, want := range []frame{
+		{websocket.PingMessage, "ping"},
+		{websocket.TextMessage, "text"},
+		{websocket.CloseMessage, string(websocket.FormatCloseMessage(4001, "bye"))},
+	} {
+		select {
+		case got := <-frames:
+			if got != want {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("frame %v not delivered", want)
+		}
+	}
+}
+
+func TestIdleKeepalive(t *testing.T) {
+	url := newTestServer(t, echoHandler)
+	ws := New(url)
+	ws.Config.KeepaliveTime = 100 * time.Millisecond
+	ws.Config.IdleKeepalive = true
+	var keepalives int32
+	ws.OnKeepalive(func() {
+		atomic.AddInt32(&keepalives, 1)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	// 持续发送消息时不发送心跳
+	for i := 0; i < 15; i++ {
+		if err := ws.SendTextMessage("busy"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&keepalives); n != 0 {
+		t.Fatalf("expected no keepalive while busy, got %d", n)
+	}
+
+	// 停止发送后满KeepaliveTime发送心跳
+	time.Sleep(250 * time.Millisecond)
+	if n := atomic.LoadInt32(&keepalives); n == 0 {
+		t.Fatal("expected keepalive once idle")
+	}
+}
+
+func TestLastHandshakeStatus(t *testing.T) {
+	ws := New(newTestServer(t, echoHandler))
+	if status := ws.LastHandshakeStatus(); status != 0 {
+		t.Fatalf("expected 0 before dialing, got %d", status)
+	}
+	ws.Connect()
+	if status := ws.LastHandshakeStatus(); status != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", status)
+	}
+	ws.Close()
+
+	rejected := New(newHTTPTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	rejected.OnConnectErrorDecision(func(err error, attempt int) bool {
+		return false
+	})
+	if err := rejected.ConnectContext(context.Background()); err == nil {
+		t.Fatal("expected handshake to be rejected")
+	}
+	if status := rejected.LastHandshakeStatus(); status != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", status)
+	}
+}
+
+func TestRecoverCallbacks(t *testing.T) {
+	ws := New(newTestServer(t, echoHandler))
+	type recovered struct {
+		value interface{}
+		stack []byte
+	}
+	panics := make(chan recovered, 2)
+	ws.OnCallbackPanic(func(value interface{}, stack []byte) {
+		panics <- recovered{value, stack}
+	})
+	received := make(chan string, 2)
+	ws.OnTextMessageReceived(func(message []byte) {
+		if string(message) == "boom" {
+			panic("handler bug")
+		}
+		received <- string(message)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendTextMessage("boom"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-panics:
+		if got.value != "handler bug" {
+			t.Fatalf("unexpected recovered value %v", got.value)
+		}
+		if !bytes.Contains(got.stack, []byte("TestRecoverCallbacks")) {
+			t.Fatalf("stack does not point at the callback:\n%s", got.stack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+
+	// 读协程继续运行，连接依然可用
+	if err := ws.SendTextMessage("ok"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != "ok" {
+			t.Fatalf("expected ok, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection stopped after callback panic")
+	}
+	if !ws.IsConnected() {
+		t.Fatal("connection closed after callback panic")
+	}
+}
+
+func TestCancelRequest(t *testing.T) {
+	// 服务端不回复，请求一直等待
+	ws := New(newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	ws.Config.IDExtractor = func(message []byte) (string, bool) {
+		return string(message), true
+	}
+	ws.Connect()
+	defer ws.Close()
+
+	results := make(map[string]chan error)
+	for _, id := range []string{"a", "b", "c"} {
+		result := make(chan error, 1)
+		results[id] = result
+		go func(id string) {
+			_, err := ws.Request(context.Background(), id, []byte(id))
+			result <- err
+		}(id)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(ws.PendingRequests()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ids := ws.PendingRequests(); !reflect.DeepEqual(ids, []string{"a", "b", "c"}) {
+		t.Fatalf("expected pending [a b c], got %v", ids)
+	}
+
+	ws.CancelRequest("b")
+	select {
+	case err := <-results["b"]:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelled request still waiting")
+	}
+	if ids := ws.PendingRequests(); !reflect.DeepEqual(ids, []string{"a", "c"}) {
+		t.Fatalf("expected pending [a c], got %v", ids)
+	}
+	for _, id := range []string{"a", "c"} {
+		select {
+		case err := <-results[id]:
+			t.Fatalf("request %s returned %v", id, err)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	// 取消不存在的请求不影响其他请求
+	ws.CancelRequest("unknown")
+	if n := len(ws.PendingRequests()); n != 2 {
+		t.Fatalf("expected 2 pending, got %d", n)
+	}
+}
+
+func TestSendBinaryStream(t *testing.T) {
+	received := make(chan []byte, 1)
+	ws := New(newTestServer(t, func(conn *websocket.Conn) {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		received <- message
+		_, _, _ = conn.ReadMessage()
+	}))
+	var progress []int64
+	var progressMu sync.Mutex
+	ws.OnSendProgress(func(bytesSent int64) {
+		progressMu.Lock()
+		progress = append(progress, bytesSent)
+		progressMu.Unlock()
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	data := make([]byte, 1<<20)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendBinaryStream(bytes.NewReader(data), 16<<10); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, data) {
+			t.Fatalf("reassembled %d bytes differ from the %d bytes sent", len(got), len(data))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not received")
+	}
+
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	if len(progress) != 64 {
+		t.Fatalf("expected 64 progress reports, got %d", len(progress))
+	}
+	for i, n := range progress {
+		if want := int64(i+1) * 16 << 10; n != want {
+			t.Fatalf("progress %d: expected %d, got %d", i, want, n)
+		}
+	}
+}
+
+func TestSendBinaryStreamReaderError(t *testing.T) {
+	testAbortedSend(t, func(ws *Wsc, r io.Reader) error {
+		return ws.SendBinaryStream(r, 4)
+	})
+}
+
+func TestPreserveMixedOrder(t *testing.T) {
+	received := make(chan Message, 8)
+	ws := New(newTestServer(t, func(conn *websocket.Conn) {
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- Message{Type: messageType, Data: message}
+		}
+	}))
+	ws.Config.PreserveMixedOrder = true
+	ws.Config.WriteBatchWindow = 5 * time.Millisecond
+	ws.Connect()
+	defer ws.Close()
+
+	// 暂停时全部入队，恢复后高优先级消息也不会越过先入队的消息
+	ws.Pause()
+	want := []Message{
+		{Type: websocket.TextMessage, Data: []byte("t1")},
+		{Type: websocket.BinaryMessage, Data: []byte("b1")},
+		{Type: websocket.TextMessage, Data: []byte("t2")},
+		{Type: websocket.TextMessage, Data: []byte("urgent")},
+		{Type: websocket.BinaryMessage, Data: []byte("b2")},
+		{Type: websocket.TextMessage, Data: []byte("t3")},
+	}
+	for _, msg := range want {
+		var err error
+		switch {
+		case string(msg.Data) == "urgent":
+			err = ws.SendTextMessagePriority(string(msg.Data), PriorityHigh)
+		case msg.Type == websocket.TextMessage:
+			err = ws.SendTextMessage(string(msg.Data))
+		default:
+			err = ws.SendBinaryMessage(msg.Data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws.Resume()
+
+	for i, w := range want {
+		select {
+		case got := <-received:
+			if got.Type != w.Type || string(got.Data) != string(w.Data) {
+				t.Fatalf("message %d: expected %d %q, got %d %q", i, w.Type, w.Data, got.Type, got.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d not received", i)
+		}
+	}
+}
+
+// panicReports 注册OnCallbackPanic，返回收到的recover值
+func panicReports(ws *Wsc) chan interface{} {
+	panics := make(chan interface{}, 16)
+	ws.OnCallbackPanic(func(recovered interface{}, stack []byte) {
+		// 重连循环中的回调会反复panic，通道满时丢弃
+		select {
+		case panics <- recovered:
+		default:
+		}
+	})
+	return panics
+}
+
+// expectPanic 等待回调panic被报告
+func expectPanic(t *testing.T, panics chan interface{}, want string) {
+	t.Helper()
+	select {
+	case got := <-panics:
+		if got != want {
+			t.Fatalf("expected recovered %q, got %v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("panic %q not reported", want)
+	}
+}
+
+func TestRecoverMessageStream(t *testing.T) {
+	ws := New(newTestServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("boom"))
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("ok"))
+		drainHandler(conn)
+	}))
+	ws.Config.StreamReads = true
+	panics := panicReports(ws)
+	received := make(chan string, 1)
+	ws.OnMessageStream(func(messageType int, r io.Reader) {
+		data, _ := io.ReadAll(r)
+		if string(data) == "boom" {
+			panic("stream")
+		}
+		received <- string(data)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	expectPanic(t, panics, "stream")
+	select {
+	case got := <-received:
+		if got != "ok" {
+			t.Fatalf("expected ok, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read goroutine stopped after stream callback panic")
+	}
+}
+
+func TestRecoverOutboundInterceptor(t *testing.T) {
+	ws := New(newTestServer(t, echoHandler))
+	panics := panicReports(ws)
+	ws.Use(func(data []byte) ([]byte, error) {
+		if string(data) == "boom" {
+			panic("interceptor")
+		}
+		return data, nil
+	}, nil)
+	sendErrs := make(chan error, 1)
+	ws.OnSentError(func(err error) {
+		sendErrs <- err
+	})
+	received := make(chan string, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- string(message)
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	if err := ws.SendTextMessage("boom"); err != nil {
+		t.Fatal(err)
+	}
+	expectPanic(t, panics, "interceptor")
+	select {
+	case err := <-sendErrs:
+		if !errors.Is(err, ErrInterceptorPanic) {
+			t.Fatalf("expected ErrInterceptorPanic, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send error not reported")
+	}
+	// sendMu已释放，写协程继续发送
+	if err := ws.SendTextMessage("ok"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != "ok" {
+			t.Fatalf("expected ok, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write goroutine stopped after interceptor panic")
+	}
+}
+
+func TestRecoverConnectLoopCallbacks(t *testing.T) {
+	// 监听后立即关闭，连接会被拒绝
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	for _, tt := range []struct {
+		name     string
+		register func(ws *Wsc)
+	}{
+		{"OnConnecting", func(ws *Wsc) {
+			ws.OnConnecting(func(url string, attempt int) { panic("OnConnecting") })
+		}},
+		{"OnConnectErrorTimed", func(ws *Wsc) {
+			ws.OnConnectErrorTimed(func(err error, elapsed time.Duration, attempt int) { panic("OnConnectErrorTimed") })
+		}},
+		{"OnConnectErrorDecision", func(ws *Wsc) {
+			ws.OnConnectErrorDecision(func(err error, attempt int) bool { panic("OnConnectErrorDecision") })
+		}},
+		{"OnBackoff", func(ws *Wsc) {
+			ws.OnBackoff(func(attempt int, delay time.Duration, err error) { panic("OnBackoff") })
+		}},
+		{"HeaderFunc", func(ws *Wsc) {
+			ws.Config.HeaderFunc = func(ctx context.Context) http.Header { panic("HeaderFunc") }
+		}},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ws := New("ws://" + addr)
+			ws.Config.MinRecTime = 5 * time.Millisecond
+			ws.Config.MaxRecTime = 5 * time.Millisecond
+			panics := panicReports(ws)
+			tt.register(ws)
+
+			// 回调panic后重连循环继续重试，直到ctx结束
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if err := ws.ConnectContext(ctx); err != context.DeadlineExceeded {
+				t.Fatalf("expected DeadlineExceeded, got %v", err)
+			}
+			expectPanic(t, panics, tt.name)
+			if n := ws.ReconnectAttempts(); n < 2 {
+				t.Fatalf("expected retries after panic, got %d attempts", n)
+			}
+		})
+	}
+}
+
+func TestRecoverResubscribe(t *testing.T) {
+	ws := New(newTestServer(t, echoHandler))
+	panics := panicReports(ws)
+	ws.Resubscribe(func() error {
+		panic("resubscribe")
+	})
+	resubscribed := make(chan struct{}, 1)
+	ws.Resubscribe(func() error {
+		resubscribed <- struct{}{}
+		return nil
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	expectPanic(t, panics, "resubscribe")
+	select {
+	case <-resubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("later resubscribe not run after panic")
+	}
+	if !ws.IsConnected() {
+		t.Fatal("connection lost after resubscribe panic")
+	}
+}
+
+func TestRecoverConnectedCallbacks(t *testing.T) {
+	ws := New(newTestServer(t, echoHandler))
+	panics := panicReports(ws)
+	ws.OnConnected(func() {
+		panic("OnConnected")
+	})
+	responses := make(chan int, 1)
+	ws.OnConnectedResponse(func(resp *http.Response) {
+		responses <- resp.StatusCode
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	expectPanic(t, panics, "OnConnected")
+	// 前一个回调panic不影响后续回调
+	select {
+	case status := <-responses:
+		if status != http.StatusSwitchingProtocols {
+			t.Fatalf("expected 101, got %d", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnConnectedResponse skipped after OnConnected panic")
+	}
+}
+
+func TestRecoverCallbackOverflow(t *testing.T) {
+	next := make(chan struct{})
+	ws := New(newTestServer(t, func(conn *websocket.Conn) {
+		for i := 0; i < 3; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(i))); err != nil {
+				return
+			}
+			if i == 0 {
+				<-next
+			}
+		}
+		drainHandler(conn)
+	}))
+	ws.Config.CallbackQueueSize = 1
+	panics := panicReports(ws)
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	ws.OnCallbackOverflow(func(messageType int, data []byte) {
+		panic("overflow")
+	})
+	ws.Connect()
+	defer ws.Close()
+	defer close(release)
+
+	<-started
+	close(next)
+	expectPanic(t, panics, "overflow")
+	if !ws.IsConnected() {
+		t.Fatal("connection lost after OnCallbackOverflow panic")
+	}
+}
+
+func TestRecoverBufferFull(t *testing.T) {
+	ws := New(newTestServer(t, drainHandler))
+	ws.Config.MessageBufferSize = 1
+	panics := panicReports(ws)
+	ws.OnBufferFull(func() {
+		panic("buffer full")
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	ws.Pause()
+	defer ws.Resume()
+	if err := ws.SendTextMessage("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendTextMessage("second"); err != ErrBuffer {
+		t.Fatalf("expected ErrBuffer, got %v", err)
+	}
+	expectPanic(t, panics, "buffer full")
+}
@@ -0,0 +1,11 @@
+module github.com/uncle-gua/wsc/wscprom
+
+go 1.16
+
+require (
+	github.com/gorilla/websocket v1.5.1
+	github.com/prometheus/client_golang v1.11.1
+	github.com/uncle-gua/wsc v0.0.0-00010101000000-000000000000
+)
+
+replace github.com/uncle-gua/wsc => ../
@@ -0,0 +1,50 @@
+// Package wscprom 将wsc客户端的运行统计导出为Prometheus指标，单独成模块避免核心模块的使用方引入Prometheus依赖
+package wscprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uncle-gua/wsc"
+)
+
+// Collectors 返回客户端的指标采集器，注册到Registry后每次采集时读取最新的统计，
+// constLabels用于区分同一进程内的多个客户端，可为nil
+func Collectors(ws *wsc.Wsc, constLabels prometheus.Labels) []prometheus.Collector {
+	counter := func(name, help string, value func(stats wsc.Stats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   "wsc",
+			Name:        name,
+			Help:        help,
+			ConstLabels: constLabels,
+		}, func() float64 {
+			return float64(value(ws.Stats()))
+		})
+	}
+	gauge := func(name, help string, value func() float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "wsc",
+			Name:        name,
+			Help:        help,
+			ConstLabels: constLabels,
+		}, value)
+	}
+	return []prometheus.Collector{
+		counter("messages_sent_total", "Number of messages written to the connection.",
+			func(stats wsc.Stats) uint64 { return stats.MessagesSent }),
+		counter("messages_received_total", "Number of messages read from the connection.",
+			func(stats wsc.Stats) uint64 { return stats.MessagesReceived }),
+		counter("sent_bytes_total", "Number of message bytes written to the connection.",
+			func(stats wsc.Stats) uint64 { return stats.BytesSent }),
+		counter("received_bytes_total", "Number of message bytes read from the connection.",
+			func(stats wsc.Stats) uint64 { return stats.BytesReceived }),
+		counter("reconnects_total", "Number of successful reconnects after a disconnect.",
+			func(stats wsc.Stats) uint64 { return stats.Reconnects }),
+		gauge("state", "Current connection state, see wsc.State.",
+			func() float64 { return float64(ws.State()) }),
+		gauge("buffer_length", "Number of messages waiting in the send buffer.",
+			func() float64 { return float64(ws.BufferLen()) }),
+		gauge("priority_buffer_length", "Number of high priority messages waiting in the send buffer.",
+			func() float64 { return float64(ws.PriorityBufferLen()) }),
+		gauge("buffer_capacity", "Capacity of the send buffer.",
+			func() float64 { return float64(ws.BufferCap()) }),
+	}
+}
@@ -0,0 +1,88 @@
+package wscprom
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uncle-gua/wsc"
+)
+
+func TestCollectors(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, message); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	ws := wsc.New("ws" + strings.TrimPrefix(srv.URL, "http"))
+	received := make(chan struct{}, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("no echo")
+	}
+
+	registry := prometheus.NewRegistry()
+	for _, c := range Collectors(ws, prometheus.Labels{"client": "test"}) {
+		registry.MustRegister(c)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() != "test" {
+				t.Fatalf("missing const label on %s", family.GetName())
+			}
+			if metric.GetCounter() != nil {
+				got[family.GetName()] = metric.GetCounter().GetValue()
+			} else {
+				got[family.GetName()] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+	want := map[string]float64{
+		"wsc_messages_sent_total":     1,
+		"wsc_messages_received_total": 1,
+		"wsc_sent_bytes_total":        5,
+		"wsc_received_bytes_total":    5,
+		"wsc_reconnects_total":        0,
+		"wsc_state":                   float64(wsc.Connected),
+		"wsc_buffer_length":           0,
+		"wsc_priority_buffer_length":  0,
+		"wsc_buffer_capacity":         256,
+	}
+	for name, value := range want {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("%s: expected %v, got %v (present %v)", name, value, v, ok)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package wsctest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uncle-gua/wsc"
+)
+
+// FakeClock 可控的时钟，时间只在调用Advance时前进，设置为wsc.Config.Clock后可确定地测试心跳和重连等待
+type FakeClock struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	now  time.Time
+	// 等待中的After
+	waiters []*fakeWaiter
+	// 运行中的定时器
+	tickers []*fakeTicker
+}
+
+// fakeWaiter 等待到期的After
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// fakeTicker FakeClock创建的定时器
+type fakeTicker struct {
+	clock  *FakeClock
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+// NewFakeClock 创建当前时间为now的时钟
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now 返回当前时间
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After 返回时间前进d之后收到当前时间的通道
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+		return w.c
+	}
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+	return w.c
+}
+
+// NewTicker 创建周期为d的定时器，时间前进时按经过的周期触发，通道已满时丢弃，与time.Ticker一致
+func (c *FakeClock) NewTicker(d time.Duration) wsc.Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance 时间前进d，触发期间到期的After和定时器
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.c <- w.at
+	}
+	c.waiters = waiters
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// BlockUntil 阻塞直到等待中的After和运行中的定时器总数不少于n，用于确认被测协程已开始等待后再调用Advance
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters)+len(c.tickers) < n {
+		c.cond.Wait()
+	}
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.period = d
+	t.next = t.clock.now.Add(d)
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	tickers := t.clock.tickers[:0]
+	for _, other := range t.clock.tickers {
+		if other != t {
+			tickers = append(tickers, other)
+		}
+	}
+	t.clock.tickers = tickers
+}
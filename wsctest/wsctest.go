@@ -0,0 +1,149 @@
+// Package wsctest 提供用于集成测试的WebSocket回显服务端，可注入延迟、指定关闭码关闭、超长消息和丢弃pong等异常，
+// 以及可控的时钟FakeClock，用于确定地测试心跳和重连等待
+package wsctest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server 回显测试服务端，原样回复收到的消息并保留消息类型
+type Server struct {
+	// ws地址，可直接用于wsc.New
+	URL string
+	// 底层HTTP测试服务端
+	HTTP *httptest.Server
+
+	mu sync.Mutex
+	// 回显前等待的时间
+	delay time.Duration
+	// 收到ping时不回复pong
+	dropPongs bool
+	// 当前的连接
+	conns map[*serverConn]struct{}
+}
+
+// serverConn 服务端连接，gorilla不支持并发写消息，回显和主动发送通过writeMu串行化
+type serverConn struct {
+	*websocket.Conn
+	writeMu sync.Mutex
+}
+
+func (c *serverConn) writeMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.WriteMessage(messageType, data)
+}
+
+// NewEchoServer 启动回显测试服务端，使用完毕后需调用Close
+func NewEchoServer() *Server {
+	s := &Server{conns: make(map[*serverConn]struct{})}
+	s.HTTP = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = "ws" + strings.TrimPrefix(s.HTTP.URL, "http")
+	return s
+}
+
+// SetDelay 设置每条消息回显前等待的时间，用于模拟慢服务端
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// DropPongs 设置收到客户端ping时是否不回复pong，用于模拟半开连接
+func (s *Server) DropPongs(drop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropPongs = drop
+}
+
+// Connections 返回当前的连接数量
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// CloseWith 向所有当前连接发送指定关闭码的关闭帧后断开连接
+func (s *Server) CloseWith(code int, text string) {
+	for _, conn := range s.snapshot() {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+		_ = conn.Close()
+	}
+}
+
+// SendOversized 向所有当前连接发送size字节的BinaryMessage，用于测试客户端的最大消息长度限制
+func (s *Server) SendOversized(size int) {
+	data := make([]byte, size)
+	for _, conn := range s.snapshot() {
+		_ = conn.writeMessage(websocket.BinaryMessage, data)
+	}
+}
+
+// Close 断开所有连接并关闭服务端
+func (s *Server) Close() {
+	for _, conn := range s.snapshot() {
+		_ = conn.Close()
+	}
+	s.HTTP.Close()
+}
+
+// snapshot 返回当前连接的副本，避免持有锁时写入连接
+func (s *Server) snapshot() []*serverConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	conn := &serverConn{Conn: c}
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	defaultPingHandler := conn.PingHandler()
+	conn.SetPingHandler(func(appData string) error {
+		s.mu.Lock()
+		drop := s.dropPongs
+		s.mu.Unlock()
+		if drop {
+			return nil
+		}
+		return defaultPingHandler(appData)
+	})
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		delay := s.delay
+		s.mu.Unlock()
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		if err := conn.writeMessage(messageType, message); err != nil {
+			return
+		}
+	}
+}
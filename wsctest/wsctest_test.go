@@ -0,0 +1,250 @@
+package wsctest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/uncle-gua/wsc"
+)
+
+func TestEcho(t *testing.T) {
+	srv := NewEchoServer()
+	defer srv.Close()
+
+	ws := wsc.New(srv.URL)
+	messages := make(chan wsc.Message, 2)
+	ws.OnMessage(func(messageType int, data []byte) {
+		messages <- wsc.Message{Type: messageType, Data: data}
+	})
+	ws.Connect()
+	defer ws.Close()
+	if n := srv.Connections(); n != 1 {
+		t.Fatalf("expected 1 connection, got %d", n)
+	}
+
+	if err := ws.SendTextMessage("text"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SendBinaryMessage([]byte("binary")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []wsc.Message{
+		{Type: websocket.TextMessage, Data: []byte("text")},
+		{Type: websocket.BinaryMessage, Data: []byte("binary")},
+	} {
+		select {
+		case got := <-messages:
+			if got.Type != want.Type || string(got.Data) != string(want.Data) {
+				t.Fatalf("expected %d %q, got %d %q", want.Type, want.Data, got.Type, got.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message not echoed")
+		}
+	}
+}
+
+func TestSetDelay(t *testing.T) {
+	srv := NewEchoServer()
+	defer srv.Close()
+	srv.SetDelay(100 * time.Millisecond)
+
+	ws := wsc.New(srv.URL)
+	received := make(chan struct{}, 1)
+	ws.OnTextMessageReceived(func(message []byte) {
+		received <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	start := time.Now()
+	if err := ws.SendTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Fatalf("echo arrived after %v, expected delay", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not echoed")
+	}
+}
+
+func TestCloseWith(t *testing.T) {
+	srv := NewEchoServer()
+	defer srv.Close()
+
+	ws := wsc.New(srv.URL)
+	type closed struct {
+		code int
+		text string
+	}
+	closes := make(chan closed, 1)
+	ws.OnClose(func(code int, text string) {
+		closes <- closed{code, text}
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	srv.CloseWith(websocket.CloseTryAgainLater, "maintenance")
+	select {
+	case got := <-closes:
+		if got.code != websocket.CloseTryAgainLater || got.text != "maintenance" {
+			t.Fatalf("unexpected close %d %q", got.code, got.text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close frame not received")
+	}
+}
+
+func TestSendOversized(t *testing.T) {
+	srv := NewEchoServer()
+	defer srv.Close()
+
+	ws := wsc.New(srv.URL)
+	ws.Config.MaxMessageSize = 16
+	limits := make(chan int64, 1)
+	ws.OnMessageTooBig(func(limit int64) {
+		limits <- limit
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	srv.SendOversized(64)
+	select {
+	case limit := <-limits:
+		if limit != 16 {
+			t.Fatalf("expected limit 16, got %d", limit)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("oversized message not detected")
+	}
+}
+
+func TestDropPongs(t *testing.T) {
+	srv := NewEchoServer()
+	defer srv.Close()
+	srv.DropPongs(true)
+
+	ws := wsc.New(srv.URL)
+	ws.Config.KeepaliveTime = 50 * time.Millisecond
+	ws.Config.ReadTimeout = 200 * time.Millisecond
+	ws.Config.EnableReconnect = false
+	disconnected := make(chan error, 1)
+	ws.OnDisconnected(func(err error) {
+		disconnected <- err
+	})
+	pongs := make(chan struct{}, 1)
+	ws.OnPongReceived(func(appData string) {
+		pongs <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	select {
+	case err := <-disconnected:
+		var netErr interface{ Timeout() bool }
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("expected read timeout, got %v", err)
+		}
+	case <-pongs:
+		t.Fatal("pong received while dropping pongs")
+	case <-time.After(time.Second):
+		t.Fatal("missing pongs not detected")
+	}
+}
+
+func TestFakeClockKeepalive(t *testing.T) {
+	srv := NewEchoServer()
+	defer srv.Close()
+	clock := NewFakeClock(time.Unix(0, 0))
+
+	ws := wsc.New(srv.URL)
+	ws.Config.Clock = clock
+	ws.Config.KeepaliveTime = 10 * time.Second
+	keepalives := make(chan struct{}, 2)
+	ws.OnKeepalive(func() {
+		keepalives <- struct{}{}
+	})
+	ws.Connect()
+	defer ws.Close()
+
+	// 等待写协程创建心跳定时器
+	clock.BlockUntil(1)
+	clock.Advance(9 * time.Second)
+	select {
+	case <-keepalives:
+		t.Fatal("keepalive fired before KeepaliveTime")
+	case <-time.After(20 * time.Millisecond):
+	}
+	for i := 0; i < 2; i++ {
+		clock.Advance(time.Second)
+		select {
+		case <-keepalives:
+		case <-time.After(time.Second):
+			t.Fatalf("keepalive %d not fired", i+1)
+		}
+		clock.Advance(9 * time.Second)
+	}
+}
+
+func TestFakeClockBackoff(t *testing.T) {
+	// 监听后立即关闭，连接会被拒绝
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	clock := NewFakeClock(time.Unix(0, 0))
+
+	ws := wsc.New("ws://" + addr)
+	ws.Config.Clock = clock
+	ws.Config.MinRecTime = time.Second
+	ws.Config.MaxRecTime = 4 * time.Second
+	ws.Config.RecFactor = 2
+	ws.Config.ReconnectJitter = false
+	attempts := make(chan int, 4)
+	ws.OnConnecting(func(url string, attempt int) {
+		attempts <- attempt
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		_ = ws.ConnectContext(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-exited
+	}()
+
+	expectAttempt := func(want int) {
+		t.Helper()
+		select {
+		case got := <-attempts:
+			if got != want {
+				t.Fatalf("expected attempt %d, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d not made", want)
+		}
+	}
+	expectAttempt(1)
+	// 每次等待的时间按RecFactor增长，时间未到时不会重试
+	for attempt, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		clock.BlockUntil(1)
+		clock.Advance(delay - time.Millisecond)
+		select {
+		case got := <-attempts:
+			t.Fatalf("attempt %d made before %v elapsed", got, delay)
+		case <-time.After(20 * time.Millisecond):
+		}
+		clock.Advance(time.Millisecond)
+		expectAttempt(attempt + 2)
+	}
+}